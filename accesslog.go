@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// accessLogTimeLayout is the timestamp layout of the access log. Fractional
+// seconds written by the high resolution log format are accepted as well.
+const accessLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// Verbs of access log events that are not operations
+const (
+	verbConnection = "connection"
+	verbClosed     = "closed"
+	verbTLS        = "TLS"
+	verbResult     = "RESULT"
+)
+
+// operationNames maps the verbs of access log requests to operation names
+var operationNames = map[string]string{
+	"BIND":    "bind",
+	"SRCH":    "search",
+	"MOD":     "modify",
+	"ADD":     "add",
+	"DEL":     "delete",
+	"MODRDN":  "modrdn",
+	"CMP":     "compare",
+	"EXT":     "extended",
+	"UNBIND":  "unbind",
+	"ABANDON": "abandon",
+}
+
+// resultTagNames maps the tag= of RESULT lines, the BER tag of the LDAP
+// response, to operation names
+var resultTagNames = map[string]string{
+	"97":  "bind",
+	"101": "search",
+	"103": "modify",
+	"105": "add",
+	"107": "delete",
+	"109": "modrdn",
+	"111": "compare",
+	"120": "extended",
+}
+
+// resultCodeNames maps the err= of RESULT lines to the symbolic LDAP result
+// code names of RFC 4511
+var resultCodeNames = map[string]string{
+	"0":  "success",
+	"1":  "operationsError",
+	"2":  "protocolError",
+	"3":  "timeLimitExceeded",
+	"4":  "sizeLimitExceeded",
+	"5":  "compareFalse",
+	"6":  "compareTrue",
+	"7":  "authMethodNotSupported",
+	"8":  "strongerAuthRequired",
+	"10": "referral",
+	"11": "adminLimitExceeded",
+	"12": "unavailableCriticalExtension",
+	"13": "confidentialityRequired",
+	"14": "saslBindInProgress",
+	"16": "noSuchAttribute",
+	"17": "undefinedAttributeType",
+	"18": "inappropriateMatching",
+	"19": "constraintViolation",
+	"20": "attributeOrValueExists",
+	"21": "invalidAttributeSyntax",
+	"32": "noSuchObject",
+	"33": "aliasProblem",
+	"34": "invalidDNSyntax",
+	"36": "aliasDereferencingProblem",
+	"48": "inappropriateAuthentication",
+	"49": "invalidCredentials",
+	"50": "insufficientAccessRights",
+	"51": "busy",
+	"52": "unavailable",
+	"53": "unwillingToPerform",
+	"54": "loopDetect",
+	"64": "namingViolation",
+	"65": "objectClassViolation",
+	"66": "notAllowedOnNonLeaf",
+	"67": "notAllowedOnRDN",
+	"68": "entryAlreadyExists",
+	"69": "objectClassModsProhibited",
+	"71": "affectsMultipleDSAs",
+	"80": "other",
+}
+
+// resultCodeName returns the symbolic name of a result code
+func resultCodeName(code string) string {
+	if name, ok := resultCodeNames[code]; ok {
+		return name
+	}
+	return "code" + code
+}
+
+// saslMechanisms are the SASL mechanisms counted by name. The server logs the
+// mechanism requested by the client before checking it, so any other name is
+// counted as SASL/other to bound the label.
+var saslMechanisms = map[string]bool{
+	"ANONYMOUS":     true,
+	"CRAM-MD5":      true,
+	"DIGEST-MD5":    true,
+	"EXTERNAL":      true,
+	"GS2-KRB5":      true,
+	"GSS-SPNEGO":    true,
+	"GSSAPI":        true,
+	"LOGIN":         true,
+	"OAUTHBEARER":   true,
+	"PLAIN":         true,
+	"SCRAM-SHA-1":   true,
+	"SCRAM-SHA-256": true,
+	"SCRAM-SHA-512": true,
+}
+
+// accessLogEvent is a parsed access log line such as
+//
+//	[18/Oct/2021:12:00:00.123456789 +0000] conn=12 op=1 SRCH base="dc=example,dc=com" scope=2 filter="(uid=jdoe)" attrs=ALL
+//
+// verb is the operation (BIND, SRCH, RESULT, ...) or one of the connection
+// level events verbConnection, verbClosed and verbTLS. attrs holds the
+// key=value pairs of the line with quotes removed.
+type accessLogEvent struct {
+	time  time.Time
+	conn  uint64
+	op    int64
+	verb  string
+	attrs map[string]string
+}
+
+// parseAccessLogLine parses a single access log line. ok is false for lines
+// that carry no event, like the header the server writes at the top of every
+// log file and internal operations.
+func parseAccessLogLine(line string) (ev accessLogEvent, ok bool, err error) {
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "[") {
+		return ev, false, nil
+	}
+
+	end := strings.IndexByte(line, ']')
+	if end < 0 {
+		return ev, false, fmt.Errorf("missing timestamp end: %q", line)
+	}
+	ev.time, err = time.Parse(accessLogTimeLayout, line[1:end])
+	if err != nil {
+		return ev, false, fmt.Errorf("invalid timestamp: %w", err)
+	}
+
+	tokens := tokenizeAccessLog(line[end+1:])
+	if len(tokens) == 0 || tokens[0].key != "conn" {
+		return ev, false, fmt.Errorf("missing conn: %q", line)
+	}
+	ev.conn, err = strconv.ParseUint(tokens[0].value, 10, 64)
+	if err != nil {
+		// internal operations are logged as conn=Internal(0)
+		if strings.HasPrefix(tokens[0].value, "Internal") {
+			return ev, false, nil
+		}
+		return ev, false, fmt.Errorf("invalid conn: %w", err)
+	}
+	tokens = tokens[1:]
+
+	ev.op = -1
+	if len(tokens) > 0 && tokens[0].key == "op" {
+		ev.op, err = strconv.ParseInt(tokens[0].value, 10, 64)
+		if err != nil {
+			return ev, false, fmt.Errorf("invalid op: %w", err)
+		}
+		tokens = tokens[1:]
+	}
+
+	ev.attrs = map[string]string{}
+	var words []string
+	for _, t := range tokens {
+		if t.key == "" {
+			words = append(words, t.value)
+		} else {
+			ev.attrs[t.key] = t.value
+		}
+	}
+	if len(words) == 0 {
+		return ev, false, fmt.Errorf("missing verb: %q", line)
+	}
+
+	switch {
+	case connectionFrom(words) >= 0:
+		// fd=64 slot=64 [SSL] connection from 10.0.0.1 to 10.0.0.2
+		i := connectionFrom(words)
+		ev.verb = verbConnection
+		if i+2 < len(words) {
+			ev.attrs["from"] = words[i+2]
+		}
+		if i+4 < len(words) && words[i+3] == "to" {
+			ev.attrs["to"] = words[i+4]
+		}
+	case words[0] == "closed":
+		// fd=64 closed - B1 or fd=64 closed error 32 (Broken pipe) - B4
+		ev.verb = verbClosed
+		if n := len(words); n >= 2 && words[n-2] == "-" {
+			ev.attrs["reason"] = words[n-1]
+		}
+	case (strings.HasPrefix(words[0], "TLS") || strings.HasPrefix(words[0], "SSL")) &&
+		len(words) >= 2 && strings.HasSuffix(words[1], "-bit"):
+		// TLS1.2 256-bit AES-GCM
+		ev.verb = verbTLS
+		ev.attrs["version"] = words[0]
+		ev.attrs["cipher"] = strings.Join(words[2:], " ")
+		ev.attrs["bits"] = strings.TrimSuffix(words[1], "-bit")
+	default:
+		ev.verb = words[0]
+	}
+
+	return ev, true, nil
+}
+
+// connectionFrom returns the index of "connection from" in words or -1
+func connectionFrom(words []string) int {
+	for i := 0; i+1 < len(words); i++ {
+		if words[i] == "connection" && words[i+1] == "from" {
+			return i
+		}
+	}
+	return -1
+}
+
+// accessLogToken is a key=value pair or, if key is empty, a bare word
+type accessLogToken struct {
+	key   string
+	value string
+}
+
+// tokenizeAccessLog splits the part of an access log line after the
+// timestamp into tokens. Quoted values may contain spaces and escaped quotes;
+// other escapes, like those in DNs, are kept as they are.
+func tokenizeAccessLog(s string) []accessLogToken {
+	var tokens []accessLogToken
+
+	for i := 0; i < len(s); {
+		if s[i] == ' ' {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(s) && s[i] != ' ' && s[i] != '=' {
+			i++
+		}
+		if i == len(s) || s[i] == ' ' {
+			tokens = append(tokens, accessLogToken{value: s[start:i]})
+			continue
+		}
+
+		key := s[start:i]
+		i++ // '='
+		if i < len(s) && s[i] == '"' {
+			i++
+			var value strings.Builder
+			for i < len(s) && s[i] != '"' {
+				if s[i] == '\\' && i+1 < len(s) && s[i+1] == '"' {
+					i++
+				}
+				value.WriteByte(s[i])
+				i++
+			}
+			i++ // closing quote
+			tokens = append(tokens, accessLogToken{key: key, value: value.String()})
+			continue
+		}
+
+		start = i
+		for i < len(s) && s[i] != ' ' {
+			i++
+		}
+		tokens = append(tokens, accessLogToken{key: key, value: s[start:i]})
+	}
+
+	return tokens
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseAccessLogLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		ok      bool
+		wantErr bool
+		want    accessLogEvent
+	}{
+		{
+			name: "search",
+			line: `[18/Oct/2021:12:00:00.123456789 +0000] conn=12 op=1 SRCH base="dc=example,dc=com" scope=2 filter="(uid=jdoe)" attrs=ALL`,
+			ok:   true,
+			want: accessLogEvent{
+				time:  time.Date(2021, 10, 18, 12, 0, 0, 123456789, time.UTC),
+				conn:  12,
+				op:    1,
+				verb:  "SRCH",
+				attrs: map[string]string{"base": "dc=example,dc=com", "scope": "2", "filter": "(uid=jdoe)", "attrs": "ALL"},
+			},
+		},
+		{
+			name: "escaped quotes",
+			line: `[18/Oct/2021:12:00:00 +0000] conn=12 op=1 SRCH base="cn=a\,b,dc=example,dc=com" scope=0 filter="(cn=\"x y\")" attrs=ALL`,
+			ok:   true,
+			want: accessLogEvent{
+				time:  time.Date(2021, 10, 18, 12, 0, 0, 0, time.UTC),
+				conn:  12,
+				op:    1,
+				verb:  "SRCH",
+				attrs: map[string]string{"base": `cn=a\,b,dc=example,dc=com`, "scope": "0", "filter": `(cn="x y")`, "attrs": "ALL"},
+			},
+		},
+		{
+			name: "closed without operation",
+			line: `[18/Oct/2021:12:00:00 +0000] conn=12 op=-1 fd=64 closed - B1`,
+			ok:   true,
+			want: accessLogEvent{
+				time:  time.Date(2021, 10, 18, 12, 0, 0, 0, time.UTC),
+				conn:  12,
+				op:    -1,
+				verb:  verbClosed,
+				attrs: map[string]string{"fd": "64", "reason": "B1"},
+			},
+		},
+		{
+			name: "closed with error",
+			line: `[18/Oct/2021:12:00:00 +0000] conn=12 op=3 fd=64 closed error 32 (Broken pipe) - B4`,
+			ok:   true,
+			want: accessLogEvent{
+				time:  time.Date(2021, 10, 18, 12, 0, 0, 0, time.UTC),
+				conn:  12,
+				op:    3,
+				verb:  verbClosed,
+				attrs: map[string]string{"fd": "64", "reason": "B4"},
+			},
+		},
+		{
+			name: "SSL connection",
+			line: `[18/Oct/2021:12:00:00 +0200] conn=7 fd=64 slot=64 SSL connection from 10.0.0.1 to 10.0.0.2`,
+			ok:   true,
+			want: accessLogEvent{
+				time:  time.Date(2021, 10, 18, 10, 0, 0, 0, time.UTC),
+				conn:  7,
+				op:    -1,
+				verb:  verbConnection,
+				attrs: map[string]string{"fd": "64", "slot": "64", "from": "10.0.0.1", "to": "10.0.0.2"},
+			},
+		},
+		{
+			name: "TLS",
+			line: `[18/Oct/2021:12:00:00 +0000] conn=7 TLS1.2 128-bit AES-GCM`,
+			ok:   true,
+			want: accessLogEvent{
+				time:  time.Date(2021, 10, 18, 12, 0, 0, 0, time.UTC),
+				conn:  7,
+				op:    -1,
+				verb:  verbTLS,
+				attrs: map[string]string{"version": "TLS1.2", "bits": "128", "cipher": "AES-GCM"},
+			},
+		},
+		{
+			name: "header",
+			line: "\t389-Directory/1.4.3.23 B2021.158.1239",
+		},
+		{
+			name: "internal operation",
+			line: `[18/Oct/2021:12:00:00 +0000] conn=Internal(0) op=0(0)(0) SRCH base="cn=config" scope=0 filter="(objectclass=*)" attrs=ALL`,
+		},
+		{
+			name:    "missing timestamp end",
+			line:    `[18/Oct/2021:12:00:00 +0000 conn=1 op=0 UNBIND`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid timestamp",
+			line:    `[yesterday] conn=1 op=0 UNBIND`,
+			wantErr: true,
+		},
+		{
+			name:    "missing conn",
+			line:    `[18/Oct/2021:12:00:00 +0000] op=0 UNBIND`,
+			wantErr: true,
+		},
+		{
+			name:    "missing verb",
+			line:    `[18/Oct/2021:12:00:00 +0000] conn=1 op=0`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev, ok, err := parseAccessLogLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+			if !ev.time.Equal(tt.want.time) {
+				t.Errorf("time = %v, want %v", ev.time, tt.want.time)
+			}
+			ev.time = tt.want.time
+			if !reflect.DeepEqual(ev, tt.want) {
+				t.Errorf("event = %+v, want %+v", ev, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenizeAccessLog(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []accessLogToken
+	}{
+		{
+			in:   ` conn=1 op=2 UNBIND`,
+			want: []accessLogToken{{"conn", "1"}, {"op", "2"}, {"", "UNBIND"}},
+		},
+		{
+			in:   `dn="" method=128`,
+			want: []accessLogToken{{"dn", ""}, {"method", "128"}},
+		},
+		{
+			in:   `filter="(cn=\"a b\")" attrs="cn mail"`,
+			want: []accessLogToken{{"filter", `(cn="a b")`}, {"attrs", "cn mail"}},
+		},
+		{
+			in:   `dn="cn=a\2Cb\\c"`,
+			want: []accessLogToken{{"dn", `cn=a\2Cb\\c`}},
+		},
+		{
+			in:   `filter="(cn=unterminated`,
+			want: []accessLogToken{{"filter", "(cn=unterminated"}},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tokenizeAccessLog(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenizeAccessLog(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAccessLogCollectorProcess(t *testing.T) {
+	f, err := os.Open("testdata/access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	c := NewAccessLogCollector(accessLogOptions{
+		unindexedSeries:  10,
+		recentUnindexed:  10,
+		failedBindSeries: 10,
+		topClients:       10,
+	})
+	if err := c.process(f); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"lines", testutil.ToFloat64(c.lines), 23},
+		{"parse errors", testutil.ToFloat64(c.parseerrors), 1},
+		{"bind operations", testutil.ToFloat64(c.operations.WithLabelValues("bind")), 2},
+		{"search operations", testutil.ToFloat64(c.operations.WithLabelValues("search")), 2},
+		{"modify operations", testutil.ToFloat64(c.operations.WithLabelValues("modify")), 1},
+		{"unbind operations", testutil.ToFloat64(c.operations.WithLabelValues("unbind")), 1},
+		{"successful binds", testutil.ToFloat64(c.results.WithLabelValues("bind", "success")), 1},
+		{"failed binds", testutil.ToFloat64(c.results.WithLabelValues("bind", "invalidCredentials")), 1},
+		{"successful searches", testutil.ToFloat64(c.results.WithLabelValues("search", "success")), 2},
+		{"denied modifications", testutil.ToFloat64(c.results.WithLabelValues("modify", "insufficientAccessRights")), 1},
+		{"unindexed searches", c.unindexedsearches.value("A", "dc=example,dc=com", "description,objectclass"), 1},
+		{"closed by unbind", testutil.ToFloat64(c.connectionsclosed.WithLabelValues("U1")), 1},
+		{"closed by bad BER", testutil.ToFloat64(c.connectionsclosed.WithLabelValues("B1")), 1},
+		{"cleartext binds", testutil.ToFloat64(c.binds.WithLabelValues("simple", "none")), 1},
+		{"TLS binds", testutil.ToFloat64(c.binds.WithLabelValues("simple", "TLS1.3")), 1},
+		{"TLS connections", testutil.ToFloat64(c.tlsconnections.WithLabelValues("TLS1.3", "256-bit AES-GCM")), 1},
+		{"failed binds by DN", c.failedbindsbydn.value("uid=app,ou=people,dc=example,dc=com"), 1},
+		{"failed binds by client", c.failedbindsbyip.value("10.0.0.3"), 1},
+		// bind, search and modify with wait, processing and total time each
+		{"duration series", float64(testutil.CollectAndCount(c.duration)), 9},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	searches := c.unindexed.list()
+	if len(searches) != 1 {
+		t.Fatalf("got %d unindexed searches, want 1", len(searches))
+	}
+	want := unindexedSearch{
+		Time:     time.Date(2021, 10, 18, 12, 0, 2, 600000000, time.UTC),
+		Client:   "10.0.0.1",
+		Conn:     1,
+		Op:       1,
+		Base:     "dc=example,dc=com",
+		Scope:    "2",
+		Filter:   `(&(objectClass=person)(description=*"quoted"*))`,
+		Notes:    "A",
+		Entries:  "3",
+		Duration: "1.500000000",
+	}
+	if got := searches[0]; !got.Time.Equal(want.Time) {
+		t.Errorf("unindexed search time = %v, want %v", got.Time, want.Time)
+	}
+	searches[0].Time = want.Time
+	if searches[0] != want {
+		t.Errorf("unindexed search = %+v, want %+v", searches[0], want)
+	}
+}
+
+func TestAccessLogCollectorInvalidUTF8(t *testing.T) {
+	lines := "[18/Oct/2021:12:00:00 +0000] conn=1 fd=64 slot=64 connection from 10.0.0.\xff to 10.0.0.2\n" +
+		"[18/Oct/2021:12:00:00 +0000] conn=1 op=1 SRCH base=\"OU=\xe9,dc=x\" scope=2 filter=\"(description=*)\" attrs=ALL\n" +
+		"[18/Oct/2021:12:00:01 +0000] conn=1 op=1 RESULT err=0 tag=101 nentries=0 etime=1.000000000 notes=A\n"
+
+	c := NewAccessLogCollector(accessLogOptions{
+		unindexedSeries:  10,
+		recentUnindexed:  10,
+		failedBindSeries: 10,
+		topClients:       10,
+	})
+	if err := c.process(strings.NewReader(lines)); err != nil {
+		t.Fatal(err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+	if _, err := reg.Gather(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.unindexedsearches.value("A", "ou=�,dc=x", "description"); got != 1 {
+		t.Errorf("unindexed searches = %v, want 1", got)
+	}
+}
+
+func TestBindMethod(t *testing.T) {
+	tests := []struct {
+		attrs map[string]string
+		want  string
+	}{
+		{map[string]string{"dn": "", "method": "128"}, "anonymous"},
+		{map[string]string{"dn": "cn=Directory Manager", "method": "128"}, "simple"},
+		{map[string]string{"dn": "", "method": "sasl", "mech": "EXTERNAL"}, "SASL/EXTERNAL"},
+		{map[string]string{"dn": "", "method": "sasl", "mech": "gssapi"}, "SASL/GSSAPI"},
+		{map[string]string{"dn": "", "method": "sasl", "mech": "MADE-UP-1"}, "SASL/other"},
+		{map[string]string{"dn": "", "method": "sasl"}, "SASL/other"},
+		{map[string]string{"dn": "", "method": "42"}, "other"},
+	}
+
+	for _, tt := range tests {
+		if got := bindMethod(accessLogEvent{attrs: tt.attrs}); got != tt.want {
+			t.Errorf("bindMethod(%v) = %q, want %q", tt.attrs, got, tt.want)
+		}
+	}
+}
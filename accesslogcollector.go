@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxAccessLogLine is the longest access log line processed, long filters
+// and attribute lists can make SRCH lines exceed the bufio default
+const maxAccessLogLine = 1024 * 1024
+
+// durationPhases maps the phase label of the duration histogram to the
+// RESULT attribute holding it
+var durationPhases = []struct {
+	phase string
+	attr  string
+}{
+	{"wait", "wtime"},
+	{"processing", "optime"},
+	{"total", "etime"},
+}
+
+// accessLogConn stores the state of a client connection seen in the access
+// log: the client address, the DN of the last successful bind and the
+// requests still waiting for their RESULT
+type accessLogConn struct {
+	ip  string
+	tls string
+	dn  string
+	ops map[int64]accessLogEvent
+}
+
+// client returns the client address of the connection, which is unknown if
+// the connection was opened before the exporter started following the log
+func (c *accessLogConn) client() string {
+	if c.ip == "" {
+		return "unknown"
+	}
+	return c.ip
+}
+
+// bindDN returns the DN the connection is bound as
+func (c *accessLogConn) bindDN() string {
+	if c.dn == "" {
+		return "anonymous"
+	}
+	return c.dn
+}
+
+// accessLogOptions limits the memory and label cardinality of the access log
+// metrics
+type accessLogOptions struct {
+	// unindexedSeries is the number of note, base and filter attribute
+	// combinations with the most unindexed searches that are exported
+	unindexedSeries int
+	// recentUnindexed is the number of unindexed searches kept for the API
+	recentUnindexed int
+	// failedBindSeries is the number of bind DNs and client addresses with
+	// the most failed binds that are exported
+	failedBindSeries int
+	// topClients is the number of client addresses and bind DNs tracked as
+	// the busiest clients
+	topClients int
+}
+
+// AccessLogCollector turns access log events into metrics. Lines are fed by
+// a tailer or any other io.Reader, so the processing can be exercised with
+// sample log files.
+type AccessLogCollector struct {
+	conns     map[uint64]*accessLogConn
+	unindexed *unindexedSearches
+	top       *topClients
+
+	lines             prometheus.Counter
+	parseerrors       prometheus.Counter
+	operations        *prometheus.CounterVec
+	duration          *prometheus.HistogramVec
+	results           *prometheus.CounterVec
+	unindexedsearches *topCounter
+	connectionsclosed *prometheus.CounterVec
+	binds             *prometheus.CounterVec
+	tlsconnections    *prometheus.CounterVec
+	failedbindsbydn   *topCounter
+	failedbindsbyip   *topCounter
+}
+
+// NewAccessLogCollector returns an initialized access log collector
+func NewAccessLogCollector(opts accessLogOptions) *AccessLogCollector {
+	return &AccessLogCollector{
+		conns:     map[uint64]*accessLogConn{},
+		unindexed: newUnindexedSearches(opts.recentUnindexed),
+		top:       newTopClients(opts.topClients),
+
+		lines: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "accesslog",
+			Name:      "lines_total",
+			Help:      "Number of access log lines processed",
+		}),
+
+		parseerrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "accesslog",
+			Name:      "parse_errors_total",
+			Help:      "Number of access log lines that could not be parsed",
+		}),
+
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "accesslog",
+			Name:      "operations_total",
+			Help:      "Number of operations requested by clients",
+		}, []string{"op"}),
+
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of operations from the access log: time spent waiting in the work queue (wtime), processing (optime) and in total (etime)",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"op", "phase"}),
+
+		results: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operation_results_total",
+			Help:      "Number of operation results from the access log by LDAP result code",
+		}, []string{"op", "result"}),
+
+		unindexedsearches: newTopCounter(opts.unindexedSeries,
+			prometheus.BuildFQName(namespace, "", "unindexed_searches_total"),
+			"Number of unindexed searches from the access log that are known to have the most frequent notes= flag (A fully unindexed, U partially unindexed, F unindexed filter component), base and filter attribute combinations, the rest are counted as other",
+			"note", "base", "attributes",
+		),
+
+		connectionsclosed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "connections_closed_total",
+			Help:      "Number of closed connections from the access log by closure code (B1 bad BER, T1 idle timeout, T2 I/O timeout, U1 client unbind, ...)",
+		}, []string{"reason"}),
+
+		binds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "binds_total",
+			Help:      "Number of bind requests from the access log by method (anonymous, simple, SASL/<mechanism>, SASL/other, other) and TLS version of the connection, none if unencrypted",
+		}, []string{"method", "tls_version"}),
+
+		tlsconnections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tls_connections_total",
+			Help:      "Number of TLS handshakes from the access log by protocol version and cipher",
+		}, []string{"version", "cipher"}),
+
+		failedbindsbydn: newTopCounter(opts.failedBindSeries,
+			prometheus.BuildFQName(namespace, "", "failed_binds_by_dn_total"),
+			"Number of binds failing with invalidCredentials from the access log that are known to come from the bind DNs with the most failures, the rest are counted as other",
+			"dn",
+		),
+
+		failedbindsbyip: newTopCounter(opts.failedBindSeries,
+			prometheus.BuildFQName(namespace, "", "failed_binds_by_client_total"),
+			"Number of binds failing with invalidCredentials from the access log that are known to come from the client addresses with the most failures, the rest are counted as other",
+			"client",
+		),
+	}
+}
+
+// Describe sends the descriptors of the access log metrics
+func (c *AccessLogCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.lines.Describe(ch)
+	c.parseerrors.Describe(ch)
+	c.operations.Describe(ch)
+	c.duration.Describe(ch)
+	c.results.Describe(ch)
+	c.unindexedsearches.describe(ch)
+	c.connectionsclosed.Describe(ch)
+	c.binds.Describe(ch)
+	c.tlsconnections.Describe(ch)
+	c.failedbindsbydn.describe(ch)
+	c.failedbindsbyip.describe(ch)
+	c.top.describe(ch)
+}
+
+// Collect sends the access log metrics
+func (c *AccessLogCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lines.Collect(ch)
+	c.parseerrors.Collect(ch)
+	c.operations.Collect(ch)
+	c.duration.Collect(ch)
+	c.results.Collect(ch)
+	c.unindexedsearches.collect(ch)
+	c.connectionsclosed.Collect(ch)
+	c.binds.Collect(ch)
+	c.tlsconnections.Collect(ch)
+	c.failedbindsbydn.collect(ch)
+	c.failedbindsbyip.collect(ch)
+	c.top.collect(ch)
+}
+
+// process handles every line read from r until EOF
+func (c *AccessLogCollector) process(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxAccessLogLine)
+	for scanner.Scan() {
+		c.processLine(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// processLine parses and handles a single access log line
+func (c *AccessLogCollector) processLine(line string) {
+	c.lines.Inc()
+
+	ev, ok, err := parseAccessLogLine(line)
+	if err != nil {
+		c.parseerrors.Inc()
+		log.WithError(err).Debug("invalid access log line")
+		return
+	}
+	if ok {
+		c.handle(ev)
+	}
+}
+
+// handle tracks the connection state and dispatches an event to the metrics
+func (c *AccessLogCollector) handle(ev accessLogEvent) {
+	conn, ok := c.conns[ev.conn]
+	if !ok {
+		conn = &accessLogConn{ops: map[int64]accessLogEvent{}}
+		c.conns[ev.conn] = conn
+	}
+
+	switch ev.verb {
+	case verbConnection:
+		// connection numbers restart with the server, so a new connection
+		// replaces whatever was left of an old one with the same number
+		conn = &accessLogConn{ip: ev.attrs["from"], ops: map[int64]accessLogEvent{}}
+		c.conns[ev.conn] = conn
+	case verbClosed:
+		delete(c.conns, ev.conn)
+		c.connectionsclosed.WithLabelValues(closeReason(ev)).Inc()
+	case verbTLS:
+		conn.tls = ev.attrs["version"]
+		cipher := ev.attrs["cipher"]
+		if bits := ev.attrs["bits"]; bits != "" {
+			cipher = bits + "-bit " + cipher
+		}
+		c.tlsconnections.WithLabelValues(conn.tls, cipher).Inc()
+	case verbResult:
+		req, ok := conn.ops[ev.op]
+		if ok {
+			delete(conn.ops, ev.op)
+		}
+		c.handleResult(conn, req, ok, ev)
+	default:
+		name, ok := operationNames[ev.verb]
+		if !ok {
+			return
+		}
+		c.operations.WithLabelValues(name).Inc()
+		c.top.addOperation(conn.client(), conn.bindDN())
+
+		if ev.verb == "BIND" {
+			tls := conn.tls
+			if tls == "" {
+				tls = "none"
+			}
+			c.binds.WithLabelValues(bindMethod(ev), tls).Inc()
+			// a bind request resets the connection to anonymous until it
+			// succeeds
+			conn.dn = ""
+		}
+
+		// UNBIND and ABANDON are never answered with a RESULT
+		if ev.verb != "UNBIND" && ev.verb != "ABANDON" {
+			conn.ops[ev.op] = ev
+		}
+	}
+}
+
+// handleResult handles a RESULT event along with the request it answers. The
+// request is missing (found is false) if it was logged before the exporter
+// started following the log.
+func (c *AccessLogCollector) handleResult(conn *accessLogConn, req accessLogEvent, found bool, res accessLogEvent) {
+	op := resultOperation(req, found, res)
+
+	if code, ok := res.attrs["err"]; ok {
+		c.results.WithLabelValues(op, resultCodeName(code)).Inc()
+	}
+
+	for _, p := range durationPhases {
+		value, ok := res.attrs[p.attr]
+		if !ok {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			log.WithError(err).Debugf("invalid %s in access log", p.attr)
+			continue
+		}
+		c.duration.WithLabelValues(op, p.phase).Observe(seconds)
+		if p.phase == "total" {
+			c.top.addDuration(conn.client(), conn.bindDN(), seconds)
+		}
+	}
+
+	if op == "bind" && res.attrs["err"] == "0" {
+		dn := res.attrs["dn"]
+		if dn == "" {
+			dn = req.attrs["dn"]
+		}
+		conn.dn = strings.ToLower(dn)
+	}
+
+	if op == "bind" && res.attrs["err"] == "49" {
+		dn := req.attrs["dn"]
+		if !found {
+			dn = "unknown"
+		}
+		c.failedbindsbydn.inc(strings.ToLower(dn))
+		c.failedbindsbyip.inc(conn.client())
+	}
+
+	if notes := unindexedNotesOf(res); len(notes) > 0 {
+		c.handleUnindexed(conn, req, res, notes)
+	}
+}
+
+// handleUnindexed counts an unindexed search and remembers it for the API
+func (c *AccessLogCollector) handleUnindexed(conn *accessLogConn, req accessLogEvent, res accessLogEvent, notes []string) {
+	base, filter := req.attrs["base"], req.attrs["filter"]
+	attributes := filterAttributes(filter)
+	// DNs are case-insensitive; the same base must not be split into
+	// several series by the spelling of the client
+	for _, note := range notes {
+		c.unindexedsearches.inc(note, strings.ToLower(base), attributes)
+	}
+
+	c.unindexed.add(unindexedSearch{
+		Time:     res.time,
+		Client:   conn.ip,
+		Conn:     res.conn,
+		Op:       res.op,
+		Base:     base,
+		Scope:    req.attrs["scope"],
+		Filter:   filter,
+		Notes:    res.attrs["notes"],
+		Entries:  res.attrs["nentries"],
+		Duration: res.attrs["etime"],
+	})
+}
+
+// resultOperation names the operation a RESULT answers, based on its tag and
+// falling back to the request
+func resultOperation(req accessLogEvent, found bool, res accessLogEvent) string {
+	if name, ok := resultTagNames[res.attrs["tag"]]; ok {
+		return name
+	}
+	if found {
+		if name, ok := operationNames[req.verb]; ok {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// closeReason returns the closure code of a closed event. Codes are a letter
+// and a digit; anything else is reported as unknown to bound the label.
+func closeReason(ev accessLogEvent) string {
+	reason := ev.attrs["reason"]
+	if len(reason) != 2 || reason[0] < 'A' || reason[0] > 'Z' || reason[1] < '0' || reason[1] > '9' {
+		return "unknown"
+	}
+	return reason
+}
+
+// bindMethod names the method of a BIND request. Simple binds are logged with
+// method=128, SASL binds with method=sasl and the mechanism in mech=. Both
+// come from the client, so unknown methods and mechanisms are reported as
+// other and SASL/other.
+func bindMethod(ev accessLogEvent) string {
+	switch ev.attrs["method"] {
+	case "128":
+		if ev.attrs["dn"] == "" {
+			return "anonymous"
+		}
+		return "simple"
+	case "sasl":
+		if mech := strings.ToUpper(ev.attrs["mech"]); saslMechanisms[mech] {
+			return "SASL/" + mech
+		}
+		return "SASL/other"
+	default:
+		return "other"
+	}
+}
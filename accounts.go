@@ -0,0 +1,216 @@
+package main
+
+import (
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// globalPolicy is the policy label of entries without a fine-grained
+// password policy
+const globalPolicy = "global"
+
+// accountStats stores the account state counts of one password policy
+type accountStats struct {
+	locked            float64
+	retryLimitReached float64
+	disabled          float64
+	passwordExpired   float64
+}
+
+// AccountCollector exposes account lockout and password policy state of the
+// entries below the configured bases
+type AccountCollector struct {
+	bases             []string
+	cache             *metricCache
+	locked            *prometheus.Desc
+	retrylimitreached *prometheus.Desc
+	disabled          *prometheus.Desc
+	passwordexpired   *prometheus.Desc
+}
+
+// NewAccountCollector returns an initialized account collector. If no bases
+// are given the naming contexts of the server are searched. The searches run
+// in the background every interval.
+func NewAccountCollector(bases []string, interval time.Duration) *AccountCollector {
+	labels := []string{"base", "policy"}
+
+	c := &AccountCollector{
+		bases: bases,
+
+		locked: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "accounts", "locked"),
+			"Number of accounts with accountUnlockTime set",
+			labels,
+			nil,
+		),
+
+		retrylimitreached: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "accounts", "retry_limit_reached"),
+			"Number of accounts with passwordRetryCount at or above the passwordMaxFailure of their policy",
+			labels,
+			nil,
+		),
+
+		disabled: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "accounts", "disabled"),
+			"Number of accounts with nsAccountLock set to true",
+			labels,
+			nil,
+		),
+
+		passwordexpired: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "accounts", "password_expired"),
+			"Number of accounts with passwordExpirationTime in the past",
+			labels,
+			nil,
+		),
+	}
+	c.cache = newMetricCache("account", interval, c.refresh)
+	return c
+}
+
+// Describe sends the descriptors of the account metrics
+func (c *AccountCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.locked
+	ch <- c.retrylimitreached
+	ch <- c.disabled
+	ch <- c.passwordexpired
+}
+
+// Collect sends the account metrics of the last background refresh
+func (c *AccountCollector) Collect(ch chan<- prometheus.Metric) {
+	c.cache.collect(ch)
+}
+
+// refresh searches the account bases into Prometheus objects
+func (c *AccountCollector) refresh(conn *ldap.Conn) ([]prometheus.Metric, error) {
+	bases, err := searchBases(conn, c.bases)
+	if err != nil {
+		return nil, err
+	}
+
+	var metrics []prometheus.Metric
+	policies := newPasswordPolicies(conn)
+	now := time.Now()
+	for _, base := range bases {
+		stats, err := getAccountStats(conn, base, policies, now)
+		if err != nil {
+			log.WithError(err).Errorf("account refresh of %s failed", base)
+			continue
+		}
+
+		for policy, s := range stats {
+			metrics = append(metrics,
+				prometheus.MustNewConstMetric(c.locked, prometheus.GaugeValue, s.locked, base, policy),
+				prometheus.MustNewConstMetric(c.retrylimitreached, prometheus.GaugeValue, s.retryLimitReached, base, policy),
+				prometheus.MustNewConstMetric(c.disabled, prometheus.GaugeValue, s.disabled, base, policy),
+				prometheus.MustNewConstMetric(c.passwordexpired, prometheus.GaugeValue, s.passwordExpired, base, policy),
+			)
+		}
+	}
+	return metrics, nil
+}
+
+// searchBases returns the configured bases or, if there are none, the naming
+// contexts published in the root DSE
+func searchBases(conn *ldap.Conn, bases []string) ([]string, error) {
+	if len(bases) > 0 {
+		return bases, nil
+	}
+
+	rootDSE, err := getRootDSE(conn)
+	if err != nil {
+		return nil, err
+	}
+	return rootDSE.namingContexts, nil
+}
+
+// getAccountStats counts the account states below base grouped by the
+// password policy that applies to each entry
+func getAccountStats(conn *ldap.Conn, base string, policies *passwordPolicies, now time.Time) (map[string]*accountStats, error) {
+	stats := map[string]*accountStats{}
+
+	err := searchPaged(conn, base, ldap.ScopeWholeSubtree,
+		"(|(accountUnlockTime=*)(passwordRetryCount=*)(nsAccountLock=*)(passwordExpirationTime=*))",
+		[]string{"accountUnlockTime", "passwordRetryCount", "nsAccountLock", "passwordExpirationTime", "pwdpolicysubentry"},
+		func(entry *ldap.Entry) {
+			policy := entry.GetEqualFoldAttributeValue("pwdpolicysubentry")
+			if policy == "" {
+				policy = globalPolicy
+			}
+
+			s, ok := stats[policy]
+			if !ok {
+				s = &accountStats{}
+				stats[policy] = s
+			}
+
+			if entry.GetEqualFoldAttributeValue("accountUnlockTime") != "" {
+				s.locked++
+			}
+
+			if retries, ok := attributeFloat(entry, "passwordRetryCount"); ok {
+				if maxFailure := policies.maxFailure(policy); maxFailure > 0 && retries >= maxFailure {
+					s.retryLimitReached++
+				}
+			}
+
+			if isTrue(entry.GetEqualFoldAttributeValue("nsAccountLock")) {
+				s.disabled++
+			}
+
+			if expiration := entry.GetEqualFoldAttributeValue("passwordExpirationTime"); expiration != "" {
+				t, err := parseGeneralizedTime(expiration)
+				if err != nil {
+					log.WithError(err).Errorf("invalid passwordExpirationTime in %s", entry.DN)
+				} else if t.Before(now) {
+					s.passwordExpired++
+				}
+			}
+		},
+	)
+
+	return stats, err
+}
+
+// passwordPolicies looks up and caches the passwordMaxFailure of the global
+// and fine-grained password policies
+type passwordPolicies struct {
+	conn        *ldap.Conn
+	maxFailures map[string]float64
+}
+
+func newPasswordPolicies(conn *ldap.Conn) *passwordPolicies {
+	return &passwordPolicies{
+		conn:        conn,
+		maxFailures: map[string]float64{},
+	}
+}
+
+// maxFailure returns the passwordMaxFailure of the given policy DN or of the
+// global policy in cn=config, 0 if it cannot be read
+func (p *passwordPolicies) maxFailure(policy string) float64 {
+	if v, ok := p.maxFailures[policy]; ok {
+		return v
+	}
+
+	dn := policy
+	if policy == globalPolicy {
+		dn = configDN
+	}
+
+	var v float64
+	entries, err := searchEntries(p.conn, dn, ldap.ScopeBaseObject, "(objectclass=*)", []string{"passwordMaxFailure"})
+	if err != nil {
+		log.WithError(err).Errorf("failed to read password policy %s", dn)
+	}
+	for _, entry := range entries {
+		v, _ = attributeFloat(entry, "passwordMaxFailure")
+	}
+
+	p.maxFailures[policy] = v
+	return v
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const backupTasksDN = "cn=backup,cn=tasks,cn=config"
+
+// backupTask stores the result of a finished backup task
+type backupTask struct {
+	completed time.Time
+	exitCode  float64
+}
+
+// backupDirData stores the newest backup found in the backup directory
+type backupDirData struct {
+	count  float64
+	newest time.Time
+	size   float64
+}
+
+// BackupCollector exposes the result of the most recent backup task and,
+// optionally, the newest backup in a local backup directory
+type BackupCollector struct {
+	dir string
+
+	// task entries expire after their TTL, so the last successful backup is
+	// remembered across scrapes
+	mu          sync.Mutex
+	lastSuccess time.Time
+
+	lastcompletion *prometheus.Desc
+	lastexitcode   *prometheus.Desc
+	lastsuccess    *prometheus.Desc
+	dircount       *prometheus.Desc
+	dirnewest      *prometheus.Desc
+	dirnewestsize  *prometheus.Desc
+}
+
+// NewBackupCollector returns an initialized backup collector. If dir is not
+// empty it is scanned for backups as well.
+func NewBackupCollector(dir string) *BackupCollector {
+	return &BackupCollector{
+		dir: dir,
+
+		lastcompletion: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "backup", "last_completion_timestamp_seconds"),
+			"Completion time of the most recent backup task",
+			nil,
+			nil,
+		),
+
+		lastexitcode: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "backup", "last_exit_code"),
+			"Exit code of the most recent backup task",
+			nil,
+			nil,
+		),
+
+		lastsuccess: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "backup", "last_success_timestamp_seconds"),
+			"Completion time of the most recent successful backup task seen by the exporter",
+			nil,
+			nil,
+		),
+
+		dircount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "backup", "dir_backups"),
+			"Number of backups in the backup directory",
+			[]string{"path"},
+			nil,
+		),
+
+		dirnewest: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "backup", "dir_newest_timestamp_seconds"),
+			"Modification time of the newest backup in the backup directory",
+			[]string{"path"},
+			nil,
+		),
+
+		dirnewestsize: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "backup", "dir_newest_size_bytes"),
+			"Size of the newest backup in the backup directory",
+			[]string{"path"},
+			nil,
+		),
+	}
+}
+
+// Describe sends the descriptors of the backup metrics
+func (c *BackupCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.lastcompletion
+	ch <- c.lastexitcode
+	ch <- c.lastsuccess
+	ch <- c.dircount
+	ch <- c.dirnewest
+	ch <- c.dirnewestsize
+}
+
+// Collect reads the backup state into Prometheus objects
+func (c *BackupCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collectTasks(ch)
+
+	if c.dir != "" {
+		data, err := getBackupDir(c.dir)
+		if err != nil {
+			log.WithError(err).Error("failed to scan backup directory")
+			return
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.dircount, prometheus.GaugeValue, data.count, c.dir)
+		if data.count > 0 {
+			ch <- prometheus.MustNewConstMetric(c.dirnewest, prometheus.GaugeValue, float64(data.newest.Unix()), c.dir)
+			ch <- prometheus.MustNewConstMetric(c.dirnewestsize, prometheus.GaugeValue, data.size, c.dir)
+		}
+	}
+}
+
+func (c *BackupCollector) collectTasks(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn, err := connect()
+	if err != nil {
+		log.WithError(err).Error("backup scrape failed")
+	} else {
+		defer release(conn)
+
+		last, lastSuccess, err := getBackupTasks(conn)
+		if err != nil {
+			log.WithError(err).Error("backup scrape failed")
+		}
+		if !last.completed.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.lastcompletion, prometheus.GaugeValue, float64(last.completed.Unix()))
+			ch <- prometheus.MustNewConstMetric(c.lastexitcode, prometheus.GaugeValue, last.exitCode)
+		}
+		if lastSuccess.After(c.lastSuccess) {
+			c.lastSuccess = lastSuccess
+		}
+	}
+
+	if !c.lastSuccess.IsZero() {
+		ch <- prometheus.MustNewConstMetric(c.lastsuccess, prometheus.GaugeValue, float64(c.lastSuccess.Unix()))
+	}
+}
+
+// getBackupTasks returns the most recent finished backup task and the
+// completion time of the most recent successful one. The completion time of a
+// finished task is its modifyTimestamp.
+func getBackupTasks(conn *ldap.Conn) (backupTask, time.Time, error) {
+	var (
+		last        backupTask
+		lastSuccess time.Time
+	)
+
+	entries, err := searchEntries(conn, backupTasksDN, ldap.ScopeSingleLevel, "(nsTaskExitCode=*)",
+		[]string{"nsTaskExitCode", "modifyTimestamp"})
+	if err != nil {
+		return last, lastSuccess, err
+	}
+
+	for _, entry := range entries {
+		exitCode, ok := attributeFloat(entry, "nsTaskExitCode")
+		if !ok {
+			continue
+		}
+		completed, err := parseGeneralizedTime(entry.GetEqualFoldAttributeValue("modifyTimestamp"))
+		if err != nil {
+			log.WithError(err).Errorf("invalid modifyTimestamp in %s", entry.DN)
+			continue
+		}
+
+		if completed.After(last.completed) {
+			last = backupTask{completed: completed, exitCode: exitCode}
+		}
+		if exitCode == 0 && completed.After(lastSuccess) {
+			lastSuccess = completed
+		}
+	}
+
+	return last, lastSuccess, nil
+}
+
+// getBackupDir scans dir for backups, which are the subdirectories holding a
+// dse.ldif, and returns the newest one
+func getBackupDir(dir string) (backupDirData, error) {
+	var data backupDirData
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return data, err
+	}
+
+	var newestPath string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(filepath.Join(path, "dse.ldif"))
+		if err != nil {
+			continue
+		}
+
+		data.count++
+		if info.ModTime().After(data.newest) {
+			data.newest = info.ModTime()
+			newestPath = path
+		}
+	}
+
+	if newestPath != "" {
+		data.size, err = dirSize(newestPath)
+	}
+
+	return data, err
+}
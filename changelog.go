@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// 389DS 1.4+ keeps one changelog per replicated backend
+	changelogBackendBase = "cn=ldbm database,cn=plugins,cn=config"
+	// older releases use a single global changelog
+	changelog5DN = "cn=changelog5,cn=config"
+)
+
+// changelogConfig stores the trimming settings of a replication changelog
+type changelogConfig struct {
+	backend      string
+	maxAge       float64
+	maxEntries   float64
+	trimInterval float64
+	encrypted    bool
+}
+
+// ChangelogCollector exposes replication changelog settings and size
+type ChangelogCollector struct {
+	dir          string
+	maxage       *prometheus.Desc
+	maxentries   *prometheus.Desc
+	triminterval *prometheus.Desc
+	encrypted    *prometheus.Desc
+	dirsize      *prometheus.Desc
+}
+
+// NewChangelogCollector returns an initialized changelog collector. If dir is
+// not empty the size of the local changelog directory is reported as well.
+func NewChangelogCollector(dir string) *ChangelogCollector {
+	return &ChangelogCollector{
+		dir: dir,
+
+		maxage: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "changelog", "max_age_seconds"),
+			"Maximum age of changelog records before trimming, 0 if unlimited",
+			[]string{"backend"},
+			nil,
+		),
+
+		maxentries: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "changelog", "max_entries"),
+			"Maximum number of changelog records before trimming, 0 if unlimited",
+			[]string{"backend"},
+			nil,
+		),
+
+		triminterval: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "changelog", "trim_interval_seconds"),
+			"Interval between changelog trimming runs",
+			[]string{"backend"},
+			nil,
+		),
+
+		encrypted: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "changelog", "encrypted"),
+			"Whether the changelog is encrypted",
+			[]string{"backend"},
+			nil,
+		),
+
+		dirsize: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "changelog", "dir_size_bytes"),
+			"Total size of the files in the local changelog directory",
+			[]string{"path"},
+			nil,
+		),
+	}
+}
+
+// Describe sends the descriptors of the changelog metrics
+func (c *ChangelogCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxage
+	ch <- c.maxentries
+	ch <- c.triminterval
+	ch <- c.encrypted
+	ch <- c.dirsize
+}
+
+// Collect reads the changelog configuration into Prometheus objects
+func (c *ChangelogCollector) Collect(ch chan<- prometheus.Metric) {
+	conn, err := connect()
+	if err != nil {
+		log.WithError(err).Error("changelog scrape failed")
+		return
+	}
+	defer release(conn)
+
+	configs, err := getChangelogConfigs(conn)
+	if err != nil {
+		log.WithError(err).Error("changelog scrape failed")
+	}
+
+	for _, cfg := range configs {
+		encrypted := 0.0
+		if cfg.encrypted {
+			encrypted = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.maxage, prometheus.GaugeValue, cfg.maxAge, cfg.backend)
+		ch <- prometheus.MustNewConstMetric(c.maxentries, prometheus.GaugeValue, cfg.maxEntries, cfg.backend)
+		ch <- prometheus.MustNewConstMetric(c.triminterval, prometheus.GaugeValue, cfg.trimInterval, cfg.backend)
+		ch <- prometheus.MustNewConstMetric(c.encrypted, prometheus.GaugeValue, encrypted, cfg.backend)
+	}
+
+	if c.dir != "" {
+		size, err := dirSize(c.dir)
+		if err != nil {
+			log.WithError(err).Error("failed to read changelog directory size")
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(c.dirsize, prometheus.GaugeValue, size, c.dir)
+	}
+}
+
+// getChangelogConfigs reads the per-backend changelogs of 389DS 1.4+ and the
+// global cn=changelog5 entry of older releases
+func getChangelogConfigs(conn *ldap.Conn) ([]changelogConfig, error) {
+	attributes := []string{
+		"nsslapd-changelogmaxage",
+		"nsslapd-changelogmaxentries",
+		"nsslapd-changelogtrim-interval",
+		"nsslapd-encryptionalgorithm",
+	}
+
+	// a failing per-backend search must not hide the cn=changelog5 entry of
+	// older servers, so it is only logged
+	entries, err := searchEntries(conn, changelogBackendBase, ldap.ScopeWholeSubtree, "(cn=changelog)", attributes)
+	if err != nil {
+		log.WithError(err).Error("failed to read the per-backend changelogs")
+	}
+
+	var configs []changelogConfig
+	for _, entry := range entries {
+		dn, err := ldap.ParseDN(entry.DN)
+		if err != nil {
+			log.WithError(err).Errorf("invalid changelog DN %s", entry.DN)
+			continue
+		}
+		// cn=changelog,cn=<backend>,cn=ldbm database,cn=plugins,cn=config;
+		// the retro changelog backend itself is cn=changelog,cn=ldbm database,...
+		if len(dn.RDNs) != 5 {
+			continue
+		}
+		configs = append(configs, parseChangelogConfig(entry, dn.RDNs[1].Attributes[0].Value))
+	}
+
+	entries, err = searchEntries(conn, changelog5DN, ldap.ScopeBaseObject, "(objectclass=*)", attributes)
+	if err != nil {
+		return configs, err
+	}
+	for _, entry := range entries {
+		configs = append(configs, parseChangelogConfig(entry, "changelog5"))
+	}
+
+	return configs, nil
+}
+
+func parseChangelogConfig(entry *ldap.Entry, backend string) changelogConfig {
+	cfg := changelogConfig{backend: backend}
+
+	if maxAge := entry.GetEqualFoldAttributeValue("nsslapd-changelogmaxage"); maxAge != "" {
+		v, err := parseAge(maxAge)
+		if err != nil {
+			log.WithError(err).Errorf("invalid nsslapd-changelogmaxage in %s", entry.DN)
+		}
+		cfg.maxAge = v
+	}
+
+	cfg.maxEntries, _ = attributeFloat(entry, "nsslapd-changelogmaxentries")
+	cfg.trimInterval, _ = attributeFloat(entry, "nsslapd-changelogtrim-interval")
+
+	algorithm := entry.GetEqualFoldAttributeValue("nsslapd-encryptionalgorithm")
+	cfg.encrypted = algorithm != "" && !strings.EqualFold(algorithm, "none")
+
+	return cfg
+}
+
+// parseAge converts 389DS age values like "30s", "12h" or "7d" to seconds.
+// A value without a unit is in seconds.
+func parseAge(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("empty age")
+	}
+
+	multiplier := 1.0
+	switch value[len(value)-1] {
+	case 's', 'S':
+		value = value[:len(value)-1]
+	case 'm', 'M':
+		multiplier = 60
+		value = value[:len(value)-1]
+	case 'h', 'H':
+		multiplier = 60 * 60
+		value = value[:len(value)-1]
+	case 'd', 'D':
+		multiplier = 24 * 60 * 60
+		value = value[:len(value)-1]
+	case 'w', 'W':
+		multiplier = 7 * 24 * 60 * 60
+		value = value[:len(value)-1]
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return v * multiplier, nil
+}
+
+// dirSize returns the total size of the regular files below path
+func dirSize(path string) (float64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return float64(size), err
+}
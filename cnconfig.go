@@ -0,0 +1,165 @@
+package main
+
+import (
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	configDN     = "cn=config"
+	ldbmConfigDN = "cn=config,cn=ldbm database,cn=plugins,cn=config"
+	ldbmDN       = "cn=ldbm database,cn=plugins,cn=config"
+)
+
+// configSetting maps a cn=config attribute to the metric exporting it
+type configSetting struct {
+	attribute string
+	desc      *prometheus.Desc
+}
+
+// ConfigCollector exposes the capacity limits configured in cn=config so
+// they can be compared with the current usage
+type ConfigCollector struct {
+	settings       []configSetting
+	dbcachesize    *prometheus.Desc
+	cachememsize   *prometheus.Desc
+	cachesize      *prometheus.Desc
+	dncachememsize *prometheus.Desc
+}
+
+func newConfigDesc(name, help string, labels []string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "config", name),
+		help,
+		labels,
+		nil,
+	)
+}
+
+// NewConfigCollector returns an initialized cn=config collector
+func NewConfigCollector() *ConfigCollector {
+	return &ConfigCollector{
+		settings: []configSetting{
+			{"nsslapd-threadnumber", newConfigDesc("threads", "Number of worker threads (nsslapd-threadnumber)", nil)},
+			{"nsslapd-maxdescriptors", newConfigDesc("max_descriptors", "Maximum number of file descriptors (nsslapd-maxdescriptors)", nil)},
+			{"nsslapd-conntablesize", newConfigDesc("conntable_size", "Size of the connection table (nsslapd-conntablesize)", nil)},
+			{"nsslapd-reservedescriptors", newConfigDesc("reserved_descriptors", "Number of file descriptors reserved for non-client use (nsslapd-reservedescriptors)", nil)},
+			{"nsslapd-idletimeout", newConfigDesc("idle_timeout_seconds", "Idle connection timeout, 0 if disabled (nsslapd-idletimeout)", nil)},
+			{"nsslapd-sizelimit", newConfigDesc("size_limit", "Maximum number of entries returned by a search, -1 if unlimited (nsslapd-sizelimit)", nil)},
+			{"nsslapd-timelimit", newConfigDesc("time_limit_seconds", "Maximum duration of a search, -1 if unlimited (nsslapd-timelimit)", nil)},
+			{"nsslapd-maxthreadsperconn", newConfigDesc("max_threads_per_conn", "Maximum number of threads per connection (nsslapd-maxthreadsperconn)", nil)},
+		},
+		dbcachesize:    newConfigDesc("db_cache_bytes", "Size of the database cache (nsslapd-dbcachesize)", nil),
+		cachememsize:   newConfigDesc("backend_entry_cache_bytes", "Maximum size of the backend entry cache (nsslapd-cachememsize)", []string{"backend"}),
+		cachesize:      newConfigDesc("backend_entry_cache_entries", "Maximum number of entries in the backend entry cache, -1 if unlimited (nsslapd-cachesize)", []string{"backend"}),
+		dncachememsize: newConfigDesc("backend_dn_cache_bytes", "Maximum size of the backend DN cache (nsslapd-dncachememsize)", []string{"backend"}),
+	}
+}
+
+// Describe sends the descriptors of the cn=config metrics
+func (c *ConfigCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, setting := range c.settings {
+		ch <- setting.desc
+	}
+	ch <- c.dbcachesize
+	ch <- c.cachememsize
+	ch <- c.cachesize
+	ch <- c.dncachememsize
+}
+
+// Collect reads the cn=config limits into Prometheus objects
+func (c *ConfigCollector) Collect(ch chan<- prometheus.Metric) {
+	conn, err := connect()
+	if err != nil {
+		log.WithError(err).Error("config scrape failed")
+		return
+	}
+	defer release(conn)
+
+	if err := c.collectServer(conn, ch); err != nil {
+		log.WithError(err).Error("config scrape failed")
+	}
+	if err := c.collectBackends(conn, ch); err != nil {
+		log.WithError(err).Error("backend config scrape failed")
+	}
+}
+
+func (c *ConfigCollector) collectServer(conn *ldap.Conn, ch chan<- prometheus.Metric) error {
+	attributes := make([]string, 0, len(c.settings))
+	for _, setting := range c.settings {
+		attributes = append(attributes, setting.attribute)
+	}
+
+	entries, err := searchEntries(conn, configDN, ldap.ScopeBaseObject, "(objectclass=*)", attributes)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		for _, setting := range c.settings {
+			if v, ok := attributeFloat(entry, setting.attribute); ok {
+				ch <- prometheus.MustNewConstMetric(setting.desc, prometheus.GaugeValue, v)
+			}
+		}
+	}
+
+	entries, err = searchEntries(conn, ldbmConfigDN, ldap.ScopeBaseObject, "(objectclass=*)", []string{"nsslapd-dbcachesize"})
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if v, ok := attributeFloat(entry, "nsslapd-dbcachesize"); ok {
+			ch <- prometheus.MustNewConstMetric(c.dbcachesize, prometheus.GaugeValue, v)
+		}
+	}
+
+	return nil
+}
+
+func (c *ConfigCollector) collectBackends(conn *ldap.Conn, ch chan<- prometheus.Metric) error {
+	entries, err := searchEntries(conn, ldbmDN, ldap.ScopeSingleLevel, "(objectclass=nsBackendInstance)",
+		[]string{"cn", "nsslapd-cachememsize", "nsslapd-cachesize", "nsslapd-dncachememsize"})
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		backend := entry.GetEqualFoldAttributeValue("cn")
+		if v, ok := attributeFloat(entry, "nsslapd-cachememsize"); ok {
+			ch <- prometheus.MustNewConstMetric(c.cachememsize, prometheus.GaugeValue, v, backend)
+		}
+		if v, ok := attributeFloat(entry, "nsslapd-cachesize"); ok {
+			ch <- prometheus.MustNewConstMetric(c.cachesize, prometheus.GaugeValue, v, backend)
+		}
+		if v, ok := attributeFloat(entry, "nsslapd-dncachememsize"); ok {
+			ch <- prometheus.MustNewConstMetric(c.dncachememsize, prometheus.GaugeValue, v, backend)
+		}
+	}
+
+	return nil
+}
+
+// backendData stores the name and suffix of an ldbm database backend
+type backendData struct {
+	name   string
+	suffix string
+}
+
+// getBackends lists the ldbm database backends
+func getBackends(conn *ldap.Conn) ([]backendData, error) {
+	entries, err := searchEntries(conn, ldbmDN, ldap.ScopeSingleLevel, "(objectclass=nsBackendInstance)",
+		[]string{"cn", "nsslapd-suffix"})
+	if err != nil {
+		return nil, err
+	}
+
+	backends := make([]backendData, 0, len(entries))
+	for _, entry := range entries {
+		backends = append(backends, backendData{
+			name:   entry.GetEqualFoldAttributeValue("cn"),
+			suffix: entry.GetEqualFoldAttributeValue("nsslapd-suffix"),
+		})
+	}
+
+	return backends, nil
+}
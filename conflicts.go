@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// conflictFilter matches replication conflict and glue entries, which
+	// are ldapsubentries on 389DS 1.4+ and only returned when the filter
+	// asks for them
+	conflictFilter = "(&(|(nsds5ReplConflict=*)(objectclass=glue))(|(objectclass=ldapsubentry)(objectclass=*)))"
+	// tombstoneFilter matches tombstones except the replica update vector
+	tombstoneFilter = "(&(objectclass=nsTombstone)(!(nsuniqueid=ffffffff-ffffffff-ffffffff-ffffffff)))"
+)
+
+// ConflictCollector exposes the number of replication conflict entries and
+// tombstones per backend
+type ConflictCollector struct {
+	counts     *metricCache
+	conflicts  *prometheus.Desc
+	tombstones *prometheus.Desc
+}
+
+// NewConflictCollector returns an initialized conflict collector. The entries
+// are counted in the background every interval since every count searches a
+// whole backend.
+func NewConflictCollector(interval time.Duration) *ConflictCollector {
+	c := &ConflictCollector{
+		conflicts: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "replication_conflicts"),
+			"Number of replication conflict and glue entries",
+			[]string{"backend", "suffix"},
+			nil,
+		),
+
+		tombstones: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "tombstones"),
+			"Number of tombstone entries",
+			[]string{"backend", "suffix"},
+			nil,
+		),
+	}
+
+	c.counts = newMetricCache("conflict count", interval, c.refresh)
+	return c
+}
+
+// Describe sends the descriptors of the conflict metrics
+func (c *ConflictCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.conflicts
+	ch <- c.tombstones
+}
+
+// Collect sends the counts of the last background refresh
+func (c *ConflictCollector) Collect(ch chan<- prometheus.Metric) {
+	c.counts.collect(ch)
+}
+
+func (c *ConflictCollector) refresh(conn *ldap.Conn) ([]prometheus.Metric, error) {
+	backends, err := getBackends(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var metrics []prometheus.Metric
+	for _, backend := range backends {
+		if backend.suffix == "" {
+			continue
+		}
+
+		conflicts, err := countMatches(conn, backend.suffix, conflictFilter)
+		if err != nil {
+			log.WithError(err).Errorf("failed to count conflicts in %s", backend.suffix)
+		} else {
+			metrics = append(metrics, prometheus.MustNewConstMetric(c.conflicts, prometheus.GaugeValue, conflicts, backend.name, backend.suffix))
+		}
+
+		tombstones, err := countMatches(conn, backend.suffix, tombstoneFilter)
+		if err != nil {
+			log.WithError(err).Errorf("failed to count tombstones in %s", backend.suffix)
+		} else {
+			metrics = append(metrics, prometheus.MustNewConstMetric(c.tombstones, prometheus.GaugeValue, tombstones, backend.name, backend.suffix))
+		}
+	}
+	return metrics, nil
+}
+
+// countMatches counts the entries below base matching filter
+func countMatches(conn *ldap.Conn, base, filter string) (float64, error) {
+	var count float64
+	err := searchPaged(conn, base, ldap.ScopeWholeSubtree, filter, []string{"1.1"}, func(*ldap.Entry) {
+		count++
+	})
+	return count, err
+}
+
+// conflictEntry is a replication conflict entry as returned by the conflict
+// API
+type conflictEntry struct {
+	DN      string     `json:"dn"`
+	Backend string     `json:"backend"`
+	Reason  string     `json:"reason"`
+	Created *time.Time `json:"created,omitempty"`
+}
+
+// conflictList is the response of the conflict API
+type conflictList struct {
+	Conflicts []conflictEntry `json:"conflicts"`
+	Truncated bool            `json:"truncated"`
+}
+
+// getConflicts lists up to limit conflict entries of all backends
+func getConflicts(conn *ldap.Conn, limit int) (conflictList, error) {
+	list := conflictList{Conflicts: []conflictEntry{}}
+
+	backends, err := getBackends(conn)
+	if err != nil {
+		return list, err
+	}
+
+	for _, backend := range backends {
+		if backend.suffix == "" {
+			continue
+		}
+
+		remaining := limit - len(list.Conflicts)
+		if remaining <= 0 {
+			list.Truncated = true
+			break
+		}
+
+		searchRequest := ldap.NewSearchRequest(
+			backend.suffix,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, remaining, 0, false,
+			conflictFilter,
+			[]string{"nsds5ReplConflict", "createTimestamp"},
+			nil,
+		)
+
+		sr, err := conn.Search(searchRequest)
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			list.Truncated = true
+		} else if err != nil {
+			return list, fmt.Errorf("failed to search %s: %w", backend.suffix, err)
+		}
+		if sr == nil {
+			continue
+		}
+
+		for _, entry := range sr.Entries {
+			conflict := conflictEntry{
+				DN:      entry.DN,
+				Backend: backend.name,
+				Reason:  entry.GetEqualFoldAttributeValue("nsds5ReplConflict"),
+			}
+			if created := entry.GetEqualFoldAttributeValue("createTimestamp"); created != "" {
+				if t, err := parseGeneralizedTime(created); err == nil {
+					conflict.Created = &t
+				}
+			}
+			list.Conflicts = append(list.Conflicts, conflict)
+		}
+	}
+
+	return list, nil
+}
+
+// conflictsHandler serves the conflict entries of the target server as JSON.
+// The number of entries is capped at maxLimit and can be lowered with the
+// limit query parameter.
+func conflictsHandler(maxLimit int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := maxLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			l, err := strconv.Atoi(v)
+			if err != nil || l <= 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			if l < limit {
+				limit = l
+			}
+		}
+
+		conn, err := connect()
+		if err != nil {
+			log.WithError(err).Error("conflict listing failed")
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer release(conn)
+
+		list, err := getConflicts(conn, limit)
+		if err != nil {
+			log.WithError(err).Error("conflict listing failed")
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(list); err != nil {
+			log.WithError(err).Error("failed to write conflict listing")
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// EntryCollector exposes the number of entries per naming context and per
+// configured subtree
+type EntryCollector struct {
+	subtrees       []string
+	suffixCounts   *metricCache
+	subtreeCounts  *metricCache
+	suffixentries  *prometheus.Desc
+	subtreeentries *prometheus.Desc
+}
+
+// NewEntryCollector returns an initialized entry count collector. Naming
+// contexts and subtrees are counted in the background, each on its own
+// interval, since counting a large suffix is expensive.
+func NewEntryCollector(subtrees []string, suffixInterval, subtreeInterval time.Duration) *EntryCollector {
+	c := &EntryCollector{
+		subtrees: subtrees,
+
+		suffixentries: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "suffix", "entries"),
+			"Number of entries below the naming context",
+			[]string{"suffix"},
+			nil,
+		),
+
+		subtreeentries: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "subtree", "entries"),
+			"Number of entries below the configured subtree",
+			[]string{"subtree"},
+			nil,
+		),
+	}
+
+	c.suffixCounts = newMetricCache("suffix entry count", suffixInterval, c.refreshSuffixes)
+	if len(subtrees) > 0 {
+		c.subtreeCounts = newMetricCache("subtree entry count", subtreeInterval, c.refreshSubtrees)
+	}
+	return c
+}
+
+// Describe sends the descriptors of the entry count metrics
+func (c *EntryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.suffixentries
+	ch <- c.subtreeentries
+}
+
+// Collect sends the counts of the last background refresh
+func (c *EntryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.suffixCounts.collect(ch)
+	if c.subtreeCounts != nil {
+		c.subtreeCounts.collect(ch)
+	}
+}
+
+func (c *EntryCollector) refreshSuffixes(conn *ldap.Conn) ([]prometheus.Metric, error) {
+	rootDSE, err := getRootDSE(conn)
+	if err != nil {
+		return nil, err
+	}
+	return countEntries(conn, rootDSE.namingContexts, c.suffixentries), nil
+}
+
+func (c *EntryCollector) refreshSubtrees(conn *ldap.Conn) ([]prometheus.Metric, error) {
+	return countEntries(conn, c.subtrees, c.subtreeentries), nil
+}
+
+func countEntries(conn *ldap.Conn, bases []string, desc *prometheus.Desc) []prometheus.Metric {
+	var metrics []prometheus.Metric
+	for _, base := range bases {
+		count, err := getEntryCount(conn, base)
+		if err != nil {
+			log.WithError(err).Errorf("failed to count entries below %s", base)
+			continue
+		}
+		metrics = append(metrics, prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, count, base))
+	}
+	return metrics
+}
+
+// getEntryCount returns the number of entries below base, not counting base
+// itself. For flat subtrees, where no child has children of its own, the
+// numSubordinates of base is exact; deeper subtrees are counted with a paged
+// search.
+func getEntryCount(conn *ldap.Conn, base string) (float64, error) {
+	entries, err := searchEntries(conn, base, ldap.ScopeBaseObject, "(objectclass=*)",
+		[]string{"numSubordinates", "nsslapd-numsubordinates"})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		count, ok := attributeFloat(entry, "numSubordinates")
+		if !ok {
+			count, ok = attributeFloat(entry, "nsslapd-numsubordinates")
+		}
+		if !ok {
+			break
+		}
+
+		deep, err := hasMatch(conn, base, ldap.ScopeSingleLevel, "(numSubordinates=*)")
+		if err != nil {
+			return 0, err
+		}
+		if !deep {
+			return count, nil
+		}
+	}
+
+	count, err := countMatches(conn, base, "(objectclass=*)")
+	if err != nil {
+		return 0, err
+	}
+
+	// the search includes base itself
+	if count > 0 {
+		count--
+	}
+	return count, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const accountPolicyConfigDN = "cn=config,cn=Account Policy Plugin,cn=plugins,cn=config"
+
+// inactivityBuckets are the upper bounds of the account inactivity histogram
+var inactivityBuckets = []float64{
+	(30 * 24 * time.Hour).Seconds(),
+	(90 * 24 * time.Hour).Seconds(),
+	(180 * 24 * time.Hour).Seconds(),
+	(365 * 24 * time.Hour).Seconds(),
+}
+
+// accountPolicyConfig stores the attribute names the Account Policy plugin
+// is configured with
+type accountPolicyConfig struct {
+	stateAttr    string
+	altStateAttr string
+	specAttr     string
+	limitAttr    string
+	limit        float64
+}
+
+// inactivityStats stores the inactivity distribution of a subtree
+type inactivityStats struct {
+	count         uint64
+	sum           float64
+	buckets       map[float64]uint64
+	inactive      float64
+	neverLoggedIn float64
+}
+
+// InactivityCollector exposes how long the accounts below the configured
+// bases have not logged in, based on the Account Policy plugin
+type InactivityCollector struct {
+	bases         []string
+	filter        string
+	cache         *metricCache
+	inactivity    *prometheus.Desc
+	inactive      *prometheus.Desc
+	neverloggedin *prometheus.Desc
+}
+
+// NewInactivityCollector returns an initialized inactivity collector. The
+// searches run in the background every interval.
+func NewInactivityCollector(bases []string, filter string, interval time.Duration) *InactivityCollector {
+	c := &InactivityCollector{
+		bases:  bases,
+		filter: filter,
+
+		inactivity: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "accounts", "inactivity_seconds"),
+			"Time since the last login recorded by the Account Policy plugin, or since the alternate state attribute like createTimestamp for accounts that never logged in",
+			[]string{"base"},
+			nil,
+		),
+
+		inactive: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "accounts", "inactive"),
+			"Number of accounts exceeding their accountInactivityLimit",
+			[]string{"base"},
+			nil,
+		),
+
+		neverloggedin: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "accounts", "never_logged_in"),
+			"Number of accounts without a recorded login",
+			[]string{"base"},
+			nil,
+		),
+	}
+	c.cache = newMetricCache("inactivity", interval, c.refresh)
+	return c
+}
+
+// Describe sends the descriptors of the inactivity metrics
+func (c *InactivityCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.inactivity
+	ch <- c.inactive
+	ch <- c.neverloggedin
+}
+
+// Collect sends the inactivity metrics of the last background refresh
+func (c *InactivityCollector) Collect(ch chan<- prometheus.Metric) {
+	c.cache.collect(ch)
+}
+
+// refresh searches the account bases into Prometheus objects
+func (c *InactivityCollector) refresh(conn *ldap.Conn) ([]prometheus.Metric, error) {
+	cfg, ok, err := getAccountPolicyConfig(conn)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	bases, err := searchBases(conn, c.bases)
+	if err != nil {
+		return nil, err
+	}
+
+	var metrics []prometheus.Metric
+	limits := newInactivityLimits(conn, cfg)
+	now := time.Now()
+	for _, base := range bases {
+		stats, err := getInactivity(conn, base, c.filter, cfg, limits, now)
+		if err != nil {
+			log.WithError(err).Errorf("inactivity refresh of %s failed", base)
+			continue
+		}
+
+		metrics = append(metrics,
+			prometheus.MustNewConstHistogram(c.inactivity, stats.count, stats.sum, stats.buckets, base),
+			prometheus.MustNewConstMetric(c.inactive, prometheus.GaugeValue, stats.inactive, base),
+			prometheus.MustNewConstMetric(c.neverloggedin, prometheus.GaugeValue, stats.neverLoggedIn, base),
+		)
+	}
+	return metrics, nil
+}
+
+// getAccountPolicyConfig reads the Account Policy plugin configuration. The
+// boolean result is false if the plugin is not configured.
+func getAccountPolicyConfig(conn *ldap.Conn) (accountPolicyConfig, bool, error) {
+	cfg := accountPolicyConfig{
+		stateAttr:    "lastLoginTime",
+		altStateAttr: "createTimestamp",
+		specAttr:     "acctPolicySubentry",
+		limitAttr:    "accountInactivityLimit",
+	}
+
+	entries, err := searchEntries(conn, accountPolicyConfigDN, ldap.ScopeBaseObject, "(objectclass=*)", nil)
+	if err != nil || len(entries) == 0 {
+		return cfg, false, err
+	}
+
+	entry := entries[0]
+	if v := entry.GetEqualFoldAttributeValue("stateattrname"); v != "" {
+		cfg.stateAttr = v
+	}
+	if v := entry.GetEqualFoldAttributeValue("altstateattrname"); v != "" {
+		cfg.altStateAttr = v
+	}
+	if v := entry.GetEqualFoldAttributeValue("specattrname"); v != "" {
+		cfg.specAttr = v
+	}
+	if v := entry.GetEqualFoldAttributeValue("limitattrname"); v != "" {
+		cfg.limitAttr = v
+	}
+	cfg.limit, _ = attributeFloat(entry, cfg.limitAttr)
+
+	return cfg, true, nil
+}
+
+// getInactivity builds the inactivity histogram of the entries below base
+// matching filter. Like the plugin, accounts that never logged in fall back
+// to the alternate state attribute, so dormant accounts still count as
+// inactive.
+func getInactivity(conn *ldap.Conn, base, filter string, cfg accountPolicyConfig, limits *inactivityLimits, now time.Time) (inactivityStats, error) {
+	stats := inactivityStats{buckets: map[float64]uint64{}}
+	for _, bound := range inactivityBuckets {
+		stats.buckets[bound] = 0
+	}
+
+	err := searchPaged(conn, base, ldap.ScopeWholeSubtree, filter, []string{cfg.stateAttr, cfg.altStateAttr, cfg.specAttr},
+		func(entry *ldap.Entry) {
+			attr := cfg.stateAttr
+			lastLogin := entry.GetEqualFoldAttributeValue(attr)
+			if lastLogin == "" {
+				stats.neverLoggedIn++
+				attr = cfg.altStateAttr
+				lastLogin = entry.GetEqualFoldAttributeValue(attr)
+				if lastLogin == "" {
+					return
+				}
+			}
+
+			t, err := parseGeneralizedTime(lastLogin)
+			if err != nil {
+				log.WithError(err).Errorf("invalid %s in %s", attr, entry.DN)
+				return
+			}
+
+			age := now.Sub(t).Seconds()
+			stats.count++
+			stats.sum += age
+			for _, bound := range inactivityBuckets {
+				if age <= bound {
+					stats.buckets[bound]++
+				}
+			}
+
+			if limit := limits.limit(entry.GetEqualFoldAttributeValue(cfg.specAttr)); limit > 0 && age > limit {
+				stats.inactive++
+			}
+		},
+	)
+
+	return stats, err
+}
+
+// inactivityLimits looks up and caches the inactivity limits of the account
+// policies
+type inactivityLimits struct {
+	conn   *ldap.Conn
+	cfg    accountPolicyConfig
+	limits map[string]float64
+}
+
+func newInactivityLimits(conn *ldap.Conn, cfg accountPolicyConfig) *inactivityLimits {
+	return &inactivityLimits{
+		conn:   conn,
+		cfg:    cfg,
+		limits: map[string]float64{},
+	}
+}
+
+// limit returns the inactivity limit in seconds of the given account policy
+// DN, falling back to the limit in the plugin configuration
+func (l *inactivityLimits) limit(policy string) float64 {
+	if policy == "" {
+		return l.cfg.limit
+	}
+	if v, ok := l.limits[policy]; ok {
+		return v
+	}
+
+	v := l.cfg.limit
+	entries, err := searchEntries(l.conn, policy, ldap.ScopeBaseObject, "(objectclass=*)", []string{l.cfg.limitAttr})
+	if err != nil {
+		log.WithError(err).Errorf("failed to read account policy %s", policy)
+	}
+	for _, entry := range entries {
+		if limit, ok := attributeFloat(entry, l.cfg.limitAttr); ok {
+			v = limit
+		}
+	}
+
+	l.limits[policy] = v
+	return v
+}
@@ -5,36 +5,296 @@ package main
 import (
 	"crypto/tls"
 	"fmt"
+	"net"
+	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-ldap/ldap/v3"
 	log "github.com/sirupsen/logrus"
 )
 
-func getStats(server string, startTLS bool, binddn, password string) (DSData, error) {
+// pageSize is the number of entries requested per page in paged searches
+const pageSize = 500
+
+// dial connects to the LDAP server, optionally upgrading the connection with
+// StartTLS and binding with the given credentials. timeout bounds the TCP
+// connect and every request on the connection, so an unresponsive server
+// fails the scrape instead of hanging it.
+func dial(server string, startTLS bool, binddn, password string, timeout time.Duration) (*ldap.Conn, error) {
 	u, err := url.ParseRequestURI(server)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	conn, err := ldap.DialURL(server)
+	conn, err := ldap.DialURL(server, ldap.DialWithDialer(&net.Dialer{Timeout: timeout}))
 	if err != nil {
-		return DSData{}, fmt.Errorf("failed to connect: %w", err)
+		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
-	defer conn.Close()
+	conn.SetTimeout(timeout)
 
 	if startTLS {
 		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
-			return DSData{}, fmt.Errorf("failed to start TLS: %w", err)
+			conn.Close()
+			return nil, fmt.Errorf("failed to start TLS: %w", err)
 		}
 	}
 
 	if binddn != "" {
 		if err := conn.Bind(binddn, password); err != nil {
-			return DSData{}, fmt.Errorf("failed to bind: %w", err)
+			conn.Close()
+			return nil, fmt.Errorf("failed to bind: %w", err)
+		}
+	}
+
+	return conn, nil
+}
+
+// sharedConn is the connection of one scrape. It is dialed once by the first
+// collector asking for it, without holding scrape.mu, so that a slow server
+// only delays the collectors that need LDAP. users and ended are guarded by
+// scrape.mu.
+type sharedConn struct {
+	once sync.Once
+	conn *ldap.Conn
+	err  error
+
+	users int
+	ended bool
+}
+
+// scrape holds the connection shared by the collectors while a scrape is
+// running. Prometheus runs the collectors concurrently, which would otherwise
+// connect and bind once per collector and scrape. conns maps the dialed
+// connections to their state so that release can tell them apart from
+// connections dialed outside of scrapes.
+var scrape struct {
+	mu      sync.Mutex
+	active  int
+	current *sharedConn
+	conns   map[*ldap.Conn]*sharedConn
+}
+
+// sharedConnHandler marks the requests to h as scrapes, during which connect
+// hands out a single shared connection
+func sharedConnHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scrape.mu.Lock()
+		scrape.active++
+		if scrape.current == nil {
+			scrape.current = &sharedConn{}
 		}
+		scrape.mu.Unlock()
+
+		defer func() {
+			scrape.mu.Lock()
+			scrape.active--
+			if scrape.active > 0 {
+				scrape.mu.Unlock()
+				return
+			}
+			sc := scrape.current
+			scrape.current = nil
+			sc.ended = true
+			conn := sc.unused()
+			scrape.mu.Unlock()
+
+			if conn != nil {
+				conn.Close()
+			}
+		}()
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// unused returns the connection of an ended scrape once nobody uses it
+// anymore and forgets about it. It must be called with scrape.mu held.
+func (sc *sharedConn) unused() *ldap.Conn {
+	if !sc.ended || sc.users > 0 || sc.conn == nil {
+		return nil
+	}
+	delete(scrape.conns, sc.conn)
+	return sc.conn
+}
+
+// connect returns a connection to the configured server. During a scrape all
+// callers share one connection, dialed by the first of them; a failed dial is
+// not retried by the others. Outside of scrapes every call dials its own
+// connection. Connections are returned with release.
+func connect() (*ldap.Conn, error) {
+	scrape.mu.Lock()
+	sc := scrape.current
+	if sc == nil {
+		scrape.mu.Unlock()
+		return dial(server, startTLS, bindDn, bindPassword, ldapTimeout)
+	}
+	sc.users++
+	scrape.mu.Unlock()
+
+	sc.once.Do(func() {
+		sc.conn, sc.err = dial(server, startTLS, bindDn, bindPassword, ldapTimeout)
+	})
+
+	scrape.mu.Lock()
+	defer scrape.mu.Unlock()
+	if sc.err != nil {
+		sc.users--
+		return nil, sc.err
+	}
+	if scrape.conns == nil {
+		scrape.conns = map[*ldap.Conn]*sharedConn{}
+	}
+	scrape.conns[sc.conn] = sc
+	return sc.conn, nil
+}
+
+// release returns a connection obtained from connect. A shared connection is
+// closed by the last user after its scrape has ended.
+func release(conn *ldap.Conn) {
+	scrape.mu.Lock()
+	sc, shared := scrape.conns[conn]
+	if shared {
+		sc.users--
+		conn = sc.unused()
+	}
+	scrape.mu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// searchEntries runs a search and returns the matching entries. A missing
+// base entry is not treated as an error since most of the optional
+// collectors read entries that only exist on some server versions.
+func searchEntries(conn *ldap.Conn, base string, scope int, filter string, attributes []string) ([]*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		base,
+		scope, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		attributes,
+		nil,
+	)
+
+	sr, err := conn.Search(searchRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, nil
 	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to search %s: %w", base, err)
+	}
+
+	return sr.Entries, nil
+}
+
+// searchPaged runs a paged search and calls fn for every matching entry, so
+// that large subtrees never have to be held in memory at once
+func searchPaged(conn *ldap.Conn, base string, scope int, filter string, attributes []string, fn func(*ldap.Entry)) error {
+	paging := ldap.NewControlPaging(pageSize)
+	searchRequest := ldap.NewSearchRequest(
+		base,
+		scope, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		attributes,
+		[]ldap.Control{paging},
+	)
+
+	for {
+		sr, err := conn.Search(searchRequest)
+		if err != nil {
+			return fmt.Errorf("failed to search %s: %w", base, err)
+		}
+
+		for _, entry := range sr.Entries {
+			fn(entry)
+		}
+
+		control, ok := ldap.FindControl(sr.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+		if !ok || len(control.Cookie) == 0 {
+			return nil
+		}
+		paging.SetCookie(control.Cookie)
+	}
+}
+
+// hasMatch reports whether any entry below base matches filter. A paged
+// search of a single entry is used so that wide containers do not run into
+// the size limit; the rest of the search is abandoned.
+func hasMatch(conn *ldap.Conn, base string, scope int, filter string) (bool, error) {
+	paging := ldap.NewControlPaging(1)
+	searchRequest := ldap.NewSearchRequest(
+		base,
+		scope, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		[]string{"1.1"},
+		[]ldap.Control{paging},
+	)
+
+	sr, err := conn.Search(searchRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to search %s: %w", base, err)
+	}
+
+	control, ok := ldap.FindControl(sr.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+	if ok && len(control.Cookie) > 0 {
+		// a page size of 0 ends the paged search
+		paging.PagingSize = 0
+		paging.SetCookie(control.Cookie)
+		if _, err := conn.Search(searchRequest); err != nil {
+			log.WithError(err).Debugf("failed to abandon paged search of %s", base)
+		}
+	}
+
+	return len(sr.Entries) > 0, nil
+}
+
+// parseGeneralizedTime parses LDAP generalized time values like
+// 20211018120000Z
+func parseGeneralizedTime(value string) (time.Time, error) {
+	return time.Parse("20060102150405Z0700", value)
+}
+
+// attributeFloat parses the first value of the named attribute
+func attributeFloat(entry *ldap.Entry, name string) (float64, bool) {
+	value := entry.GetEqualFoldAttributeValue(name)
+	if value == "" {
+		return 0, false
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.WithError(err).Errorf("invalid %s in %s", name, entry.DN)
+		return 0, false
+	}
+
+	return v, true
+}
+
+// isTrue reports whether a cn=config value is one of the spellings 389DS
+// accepts for "on"
+func isTrue(value string) bool {
+	switch strings.ToLower(value) {
+	case "on", "true", "yes", "1":
+		return true
+	}
+	return false
+}
+
+func getStats() (DSData, error) {
+	conn, err := connect()
+	if err != nil {
+		return DSData{}, err
+	}
+	defer release(conn)
+
+	tlsinfo := getTLSData(conn)
 
 	searchRequest := ldap.NewSearchRequest(
 		"cn=snmp, cn=monitor",
@@ -348,5 +608,6 @@ func getStats(server string, startTLS bool, binddn, password string) (DSData, er
 		referralsreturned:          referralsreturned64,
 		cacheentries:               cacheentries64,
 		cachehits:                  cachehits64,
+		tlsinfo:                    tlsinfo,
 	}, nil
 }
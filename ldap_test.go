@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestConnectUnresponsiveServer binds against a server that accepts the
+// connection but never answers. Other requests must not wait for the bind,
+// and the bind must give up after the timeout.
+func TestConnectUnresponsiveServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	defer func(s string, tls bool, dn, pw string, timeout time.Duration) {
+		server, startTLS, bindDn, bindPassword, ldapTimeout = s, tls, dn, pw, timeout
+	}(server, startTLS, bindDn, bindPassword, ldapTimeout)
+	server, startTLS, bindDn, bindPassword = "ldap://"+ln.Addr().String(), false, "cn=exporter", "secret"
+	ldapTimeout = 500 * time.Millisecond
+
+	connected := make(chan error, 1)
+	started := make(chan struct{})
+	h := sharedConnHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/slow" {
+			return
+		}
+		close(started)
+		conn, err := connect()
+		if err == nil {
+			release(conn)
+		}
+		connected <- err
+	}))
+
+	start := time.Now()
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	<-started
+	// give the first request time to start binding
+	time.Sleep(50 * time.Millisecond)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fast", nil))
+	if d := time.Since(start); d > 300*time.Millisecond {
+		t.Errorf("second scrape took %v, want it not to wait for the bind", d)
+	}
+
+	select {
+	case err := <-connected:
+		if err == nil {
+			t.Error("connect succeeded, want a timeout")
+		}
+		if d := time.Since(start); d < ldapTimeout {
+			t.Errorf("connect failed after %v, want it to wait for the bind: %v", d, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connect did not time out")
+	}
+}
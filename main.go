@@ -8,6 +8,8 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -23,6 +25,7 @@ var (
 	startTLS     bool
 	bindDn       string
 	bindPassword string
+	ldapTimeout  time.Duration
 )
 
 // DSData stores metrics from 389DS
@@ -56,6 +59,7 @@ type DSData struct {
 	referralsreturned          float64
 	cacheentries               float64
 	cachehits                  float64
+	tlsinfo                    tlsData
 }
 
 // Exporter stores metrics from 389DS
@@ -89,6 +93,9 @@ type Exporter struct {
 	referralsreturned          *prometheus.Desc
 	cacheentries               *prometheus.Desc
 	cachehits                  *prometheus.Desc
+	tlscertnotafter            *prometheus.Desc
+	tlschainlength             *prometheus.Desc
+	tlspersonality             *prometheus.Desc
 }
 
 // NewExporter returns an initialized exporter
@@ -296,6 +303,27 @@ func NewExporter() *Exporter {
 			nil,
 			nil,
 		),
+
+		tlscertnotafter: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "tls", "cert_not_after_seconds"),
+			"Expiry time of the certificates presented by the server in unix seconds",
+			[]string{"subject", "issuer", "serial"},
+			nil,
+		),
+
+		tlschainlength: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "tls", "chain_length"),
+			"Number of certificates presented by the server",
+			nil,
+			nil,
+		),
+
+		tlspersonality: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "tls", "personality_info"),
+			"Nickname of the configured server certificate (nsSSLPersonalitySSL)",
+			[]string{"nickname"},
+			nil,
+		),
 	}
 }
 
@@ -330,11 +358,14 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.referralsreturned
 	ch <- e.cacheentries
 	ch <- e.cachehits
+	ch <- e.tlscertnotafter
+	ch <- e.tlschainlength
+	ch <- e.tlspersonality
 }
 
 // Collect reads stats from LDAP connection object into Prometheus objects
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	data, err := getStats(server, startTLS, bindDn, bindPassword)
+	data, err := getStats()
 	ch <- prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, float64(data.up))
 	if err != nil {
 		log.WithError(err).Error("scrape failed")
@@ -368,6 +399,17 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(e.referralsreturned, prometheus.CounterValue, data.referralsreturned)
 	ch <- prometheus.MustNewConstMetric(e.cacheentries, prometheus.CounterValue, data.cacheentries)
 	ch <- prometheus.MustNewConstMetric(e.cachehits, prometheus.CounterValue, data.cachehits)
+
+	if data.tlsinfo.enabled {
+		for _, cert := range data.tlsinfo.certificates {
+			ch <- prometheus.MustNewConstMetric(e.tlscertnotafter, prometheus.GaugeValue, float64(cert.NotAfter.Unix()),
+				cert.Subject.String(), cert.Issuer.String(), cert.SerialNumber.Text(16))
+		}
+		ch <- prometheus.MustNewConstMetric(e.tlschainlength, prometheus.GaugeValue, float64(len(data.tlsinfo.certificates)))
+		if data.tlsinfo.personality != "" {
+			ch <- prometheus.MustNewConstMetric(e.tlspersonality, prometheus.GaugeValue, 1, data.tlsinfo.personality)
+		}
+	}
 }
 
 func LookupEnvOrString(key string, defaultVal string) string {
@@ -388,14 +430,85 @@ func LookupEnvOrBool(key string, defaultVal bool) bool {
 	return defaultVal
 }
 
+func LookupEnvOrInt(key string, defaultVal int) int {
+	if val, ok := os.LookupEnv(key); ok {
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			log.Fatalf("LookupEnvOrInt[%s]: %v", key, err)
+		}
+		return v
+	}
+	return defaultVal
+}
+
+func LookupEnvOrDuration(key string, defaultVal time.Duration) time.Duration {
+	if val, ok := os.LookupEnv(key); ok {
+		v, err := time.ParseDuration(val)
+		if err != nil {
+			log.Fatalf("LookupEnvOrDuration[%s]: %v", key, err)
+		}
+		return v
+	}
+	return defaultVal
+}
+
+// splitList splits a separated flag value, dropping empty items
+func splitList(value, sep string) []string {
+	var items []string
+	for _, item := range strings.Split(value, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func main() {
 	var (
-		listenAddress    = flag.String("web.listen-address", LookupEnvOrString("DS_LISTEN_ADDRESS", ":9313"), "Address to listen on for web interface and telemetry (DS_LISTEN_ADDRESS)")
-		metricsPath      = flag.String("web.telemetry-path", LookupEnvOrString("DS_TELEMETRY_PATH", "/metrics"), "Path under which to expose metrics (DS_TELEMETRY_PATH)")
-		ldapServer       = flag.String("ldap.ServerURL", LookupEnvOrString("DS_SERVER_URL", "ldap://localhost"), "URL of the target LDAP server (DS_SERVER_URL)")
-		ldapStartTLS     = flag.Bool("ldap.StartTLS", LookupEnvOrBool("DS_STARTTLS", true), "Use StartTLS (DS_STARTTLS)")
-		ldapBindDN       = flag.String("ldap.BindDN", LookupEnvOrString("DS_BINDDN", ""), "DN to bind to the target LDAP server (DS_BINDDN)")
-		ldapBindPassword = flag.String("ldap.BindPassword", LookupEnvOrString("DS_BINDPASSWORD", ""), "Password to bind to the target LDAP server (DS_BINDPASSWORD)")
+		listenAddress         = flag.String("web.listen-address", LookupEnvOrString("DS_LISTEN_ADDRESS", ":9313"), "Address to listen on for web interface and telemetry (DS_LISTEN_ADDRESS)")
+		metricsPath           = flag.String("web.telemetry-path", LookupEnvOrString("DS_TELEMETRY_PATH", "/metrics"), "Path under which to expose metrics (DS_TELEMETRY_PATH)")
+		ldapServer            = flag.String("ldap.ServerURL", LookupEnvOrString("DS_SERVER_URL", "ldap://localhost"), "URL of the target LDAP server (DS_SERVER_URL)")
+		ldapStartTLS          = flag.Bool("ldap.StartTLS", LookupEnvOrBool("DS_STARTTLS", true), "Use StartTLS (DS_STARTTLS)")
+		ldapBindDN            = flag.String("ldap.BindDN", LookupEnvOrString("DS_BINDDN", ""), "DN to bind to the target LDAP server (DS_BINDDN)")
+		ldapBindPassword      = flag.String("ldap.BindPassword", LookupEnvOrString("DS_BINDPASSWORD", ""), "Password to bind to the target LDAP server (DS_BINDPASSWORD)")
+		ldapTimeoutFlag       = flag.Duration("ldap.Timeout", LookupEnvOrDuration("DS_LDAP_TIMEOUT", 10*time.Second), "Timeout to connect to the target LDAP server and of every request (DS_LDAP_TIMEOUT)")
+		collectRootDSE        = flag.Bool("collector.rootdse", LookupEnvOrBool("DS_COLLECTOR_ROOTDSE", true), "Collect server information from the root DSE (DS_COLLECTOR_ROOTDSE)")
+		collectNDNCache       = flag.Bool("collector.ndncache", LookupEnvOrBool("DS_COLLECTOR_NDNCACHE", true), "Collect normalized DN cache statistics (DS_COLLECTOR_NDNCACHE)")
+		collectConfig         = flag.Bool("collector.config", LookupEnvOrBool("DS_COLLECTOR_CONFIG", false), "Collect capacity limits from cn=config (DS_COLLECTOR_CONFIG)")
+		collectPlugins        = flag.Bool("collector.plugins", LookupEnvOrBool("DS_COLLECTOR_PLUGINS", false), "Collect plugin inventory and status (DS_COLLECTOR_PLUGINS)")
+		requiredPlugins       = flag.String("plugins.required", LookupEnvOrString("DS_PLUGINS_REQUIRED", ""), "Comma separated names of plugins that must be enabled (DS_PLUGINS_REQUIRED)")
+		collectAccounts       = flag.Bool("collector.accounts", LookupEnvOrBool("DS_COLLECTOR_ACCOUNTS", false), "Collect account lockout and password policy state (DS_COLLECTOR_ACCOUNTS)")
+		accountBases          = flag.String("accounts.bases", LookupEnvOrString("DS_ACCOUNTS_BASES", ""), "Semicolon separated search bases of the account collectors, defaults to all naming contexts (DS_ACCOUNTS_BASES)")
+		collectPasswordExpiry = flag.Bool("collector.passwordexpiry", LookupEnvOrBool("DS_COLLECTOR_PASSWORDEXPIRY", false), "Collect password expiry histograms (DS_COLLECTOR_PASSWORDEXPIRY)")
+		accountInterval       = flag.Duration("accounts.interval", LookupEnvOrDuration("DS_ACCOUNTS_INTERVAL", 5*time.Minute), "Interval of the background searches of the account collectors (DS_ACCOUNTS_INTERVAL)")
+		accountFilter         = flag.String("accounts.filter", LookupEnvOrString("DS_ACCOUNTS_FILTER", "(objectclass=person)"), "Filter selecting the accounts of the password expiry and inactivity collectors (DS_ACCOUNTS_FILTER)")
+		serviceAccountFilter  = flag.String("accounts.service-filter", LookupEnvOrString("DS_ACCOUNTS_SERVICE_FILTER", ""), "Filter selecting service accounts, reported separately by the password expiry collector (DS_ACCOUNTS_SERVICE_FILTER)")
+		collectInactivity     = flag.Bool("collector.inactivity", LookupEnvOrBool("DS_COLLECTOR_INACTIVITY", false), "Collect account inactivity from the Account Policy plugin (DS_COLLECTOR_INACTIVITY)")
+		queriesFile           = flag.String("queries.file", LookupEnvOrString("DS_QUERIES_FILE", ""), "JSON file defining custom LDAP queries to export (DS_QUERIES_FILE)")
+		queriesInterval       = flag.Duration("queries.interval", LookupEnvOrDuration("DS_QUERIES_INTERVAL", time.Minute), "Interval of the background searches of custom queries without an interval of their own (DS_QUERIES_INTERVAL)")
+		collectEntries        = flag.Bool("collector.entries", LookupEnvOrBool("DS_COLLECTOR_ENTRIES", false), "Collect entry counts per naming context and subtree (DS_COLLECTOR_ENTRIES)")
+		entrySubtrees         = flag.String("entries.subtrees", LookupEnvOrString("DS_ENTRIES_SUBTREES", ""), "Semicolon separated subtrees to count entries in (DS_ENTRIES_SUBTREES)")
+		entrySuffixInterval   = flag.Duration("entries.suffix-interval", LookupEnvOrDuration("DS_ENTRIES_SUFFIX_INTERVAL", time.Hour), "Interval of the background counts of the naming contexts (DS_ENTRIES_SUFFIX_INTERVAL)")
+		entrySubtreeInterval  = flag.Duration("entries.subtree-interval", LookupEnvOrDuration("DS_ENTRIES_SUBTREE_INTERVAL", 5*time.Minute), "Interval of the background counts of the subtrees (DS_ENTRIES_SUBTREE_INTERVAL)")
+		collectConflicts      = flag.Bool("collector.conflicts", LookupEnvOrBool("DS_COLLECTOR_CONFLICTS", false), "Collect replication conflict and tombstone counts (DS_COLLECTOR_CONFLICTS)")
+		conflictsInterval     = flag.Duration("conflicts.interval", LookupEnvOrDuration("DS_CONFLICTS_INTERVAL", 5*time.Minute), "Interval of the background counts of replication conflicts and tombstones (DS_CONFLICTS_INTERVAL)")
+		conflictsAPI          = flag.Bool("web.conflicts-api", LookupEnvOrBool("DS_CONFLICTS_API", false), "Serve the replication conflict entries as JSON on /api/replication/conflicts (DS_CONFLICTS_API)")
+		conflictsLimit        = flag.Int("web.conflicts-limit", LookupEnvOrInt("DS_CONFLICTS_LIMIT", 1000), "Maximum number of conflict entries returned by the conflict API (DS_CONFLICTS_LIMIT)")
+		collectTasks          = flag.Bool("collector.tasks", LookupEnvOrBool("DS_COLLECTOR_TASKS", false), "Collect server task progress from cn=tasks,cn=config (DS_COLLECTOR_TASKS)")
+		collectBackup         = flag.Bool("collector.backup", LookupEnvOrBool("DS_COLLECTOR_BACKUP", false), "Collect the result of the most recent backup task (DS_COLLECTOR_BACKUP)")
+		backupDir             = flag.String("backup.dir", LookupEnvOrString("DS_BACKUP_DIR", ""), "Local backup directory to scan for the newest backup (DS_BACKUP_DIR)")
+		accessLogPath         = flag.String("accesslog.path", LookupEnvOrString("DS_ACCESSLOG_PATH", ""), "Access log to follow, e.g. /var/log/dirsrv/slapd-*/access (DS_ACCESSLOG_PATH)")
+		accessLogPoll         = flag.Duration("accesslog.poll-interval", LookupEnvOrDuration("DS_ACCESSLOG_POLL_INTERVAL", time.Second), "Interval to check the access log for new lines (DS_ACCESSLOG_POLL_INTERVAL)")
+		accessLogAPI          = flag.Bool("web.accesslog-api", LookupEnvOrBool("DS_ACCESSLOG_API", false), "Serve details derived from the access log as JSON under /api/accesslog/ (DS_ACCESSLOG_API)")
+		accessLogPosition     = flag.String("accesslog.position-file", LookupEnvOrString("DS_ACCESSLOG_POSITION_FILE", ""), "File to persist the access log position in to resume after a restart (DS_ACCESSLOG_POSITION_FILE)")
+		drainRotated          = flag.Bool("accesslog.drain-rotated", LookupEnvOrBool("DS_ACCESSLOG_DRAIN_ROTATED", true), "Read the rest of a rotated access log before switching to the new one (DS_ACCESSLOG_DRAIN_ROTATED)")
+		unindexedSeries       = flag.Int("accesslog.unindexed-series", LookupEnvOrInt("DS_ACCESSLOG_UNINDEXED_SERIES", 100), "Number of base and filter attribute combinations with the most unindexed searches exported (DS_ACCESSLOG_UNINDEXED_SERIES)")
+		recentUnindexed       = flag.Int("accesslog.recent-unindexed", LookupEnvOrInt("DS_ACCESSLOG_RECENT_UNINDEXED", 100), "Number of recent unindexed searches listed by the access log API (DS_ACCESSLOG_RECENT_UNINDEXED)")
+		failedBindSeries      = flag.Int("accesslog.failed-bind-series", LookupEnvOrInt("DS_ACCESSLOG_FAILED_BIND_SERIES", 100), "Number of bind DNs and client addresses with the most failed binds exported (DS_ACCESSLOG_FAILED_BIND_SERIES)")
+		topClients            = flag.Int("accesslog.top-clients", LookupEnvOrInt("DS_ACCESSLOG_TOP_CLIENTS", 20), "Number of busiest client addresses and bind DNs tracked from the access log (DS_ACCESSLOG_TOP_CLIENTS)")
+		collectChangelog      = flag.Bool("collector.changelog", LookupEnvOrBool("DS_COLLECTOR_CHANGELOG", false), "Collect replication changelog settings (DS_COLLECTOR_CHANGELOG)")
+		changelogDir          = flag.String("changelog.dir", LookupEnvOrString("DS_CHANGELOG_DIR", ""), "Local changelog directory to report the size of (DS_CHANGELOG_DIR)")
+		collectRetroChangelog = flag.Bool("collector.retrochangelog", LookupEnvOrBool("DS_COLLECTOR_RETROCHANGELOG", false), "Collect retro changelog change numbers (DS_COLLECTOR_RETROCHANGELOG)")
 	)
 	flag.Parse()
 
@@ -403,12 +516,81 @@ func main() {
 	startTLS = *ldapStartTLS
 	bindDn = *ldapBindDN
 	bindPassword = *ldapBindPassword
+	ldapTimeout = *ldapTimeoutFlag
 
 	log.Infoln("Connecting to LDAP Server: ", *ldapServer)
 
 	prometheus.MustRegister(NewExporter())
+	if *collectRootDSE {
+		prometheus.MustRegister(NewRootDSECollector())
+	}
+	if *collectNDNCache {
+		prometheus.MustRegister(NewNDNCacheCollector())
+	}
+	if *collectConfig {
+		prometheus.MustRegister(NewConfigCollector())
+	}
+	if *collectPlugins {
+		prometheus.MustRegister(NewPluginCollector(splitList(*requiredPlugins, ",")))
+	}
+	if *collectAccounts {
+		prometheus.MustRegister(NewAccountCollector(splitList(*accountBases, ";"), *accountInterval))
+	}
+	if *collectPasswordExpiry {
+		prometheus.MustRegister(NewPasswordExpiryCollector(splitList(*accountBases, ";"), *accountFilter, *serviceAccountFilter, *accountInterval))
+	}
+	if *collectInactivity {
+		prometheus.MustRegister(NewInactivityCollector(splitList(*accountBases, ";"), *accountFilter, *accountInterval))
+	}
+	if *queriesFile != "" {
+		queries, err := loadQueries(*queriesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		prometheus.MustRegister(NewQueryCollector(queries, *queriesInterval))
+	}
+	if *collectEntries {
+		prometheus.MustRegister(NewEntryCollector(splitList(*entrySubtrees, ";"), *entrySuffixInterval, *entrySubtreeInterval))
+	}
+	if *collectConflicts {
+		prometheus.MustRegister(NewConflictCollector(*conflictsInterval))
+	}
+	if *collectTasks {
+		prometheus.MustRegister(NewTaskCollector())
+	}
+	if *collectBackup {
+		prometheus.MustRegister(NewBackupCollector(*backupDir))
+	}
+	if *accessLogPath != "" {
+		path, err := resolveAccessLogPath(*accessLogPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		accessLog := NewAccessLogCollector(accessLogOptions{
+			unindexedSeries:  *unindexedSeries,
+			recentUnindexed:  *recentUnindexed,
+			failedBindSeries: *failedBindSeries,
+			topClients:       *topClients,
+		})
+		prometheus.MustRegister(accessLog)
+		if *accessLogAPI {
+			http.Handle("/api/accesslog/unindexed", accessLog.unindexed.handler())
+			http.Handle("/api/accesslog/top-clients", accessLog.top.handler())
+		}
+		log.Infoln("Following access log", path)
+		go newTailer(path, *accessLogPoll, *accessLogPosition, *drainRotated).run(accessLog.processLine)
+	}
+	if *collectChangelog {
+		prometheus.MustRegister(NewChangelogCollector(*changelogDir))
+	}
+	if *collectRetroChangelog {
+		prometheus.MustRegister(NewRetroChangelogCollector())
+	}
 
-	http.Handle(*metricsPath, promhttp.Handler())
+	http.Handle(*metricsPath, sharedConnHandler(promhttp.Handler()))
+	if *conflictsAPI {
+		http.Handle("/api/replication/conflicts", conflictsHandler(*conflictsLimit))
+	}
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
              <head><title>389-DS Exporter</title></head>
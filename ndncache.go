@@ -0,0 +1,159 @@
+package main
+
+import (
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const ldbmMonitorDN = "cn=monitor,cn=ldbm database,cn=plugins,cn=config"
+
+// ndnCacheData stores the normalized DN cache statistics
+type ndnCacheData struct {
+	tries     float64
+	hits      float64
+	misses    float64
+	evictions float64
+	size      float64
+	maxSize   float64
+	count     float64
+}
+
+// NDNCacheCollector exposes the normalized DN cache statistics
+type NDNCacheCollector struct {
+	tries     *prometheus.Desc
+	hits      *prometheus.Desc
+	misses    *prometheus.Desc
+	evictions *prometheus.Desc
+	size      *prometheus.Desc
+	maxsize   *prometheus.Desc
+	count     *prometheus.Desc
+}
+
+// NewNDNCacheCollector returns an initialized normalized DN cache collector
+func NewNDNCacheCollector() *NDNCacheCollector {
+	return &NDNCacheCollector{
+		tries: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "ndn_cache", "tries_total"),
+			"Number of normalized DN cache lookups",
+			nil,
+			nil,
+		),
+
+		hits: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "ndn_cache", "hits_total"),
+			"Number of normalized DN cache hits",
+			nil,
+			nil,
+		),
+
+		misses: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "ndn_cache", "misses_total"),
+			"Number of normalized DN cache misses",
+			nil,
+			nil,
+		),
+
+		evictions: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "ndn_cache", "evictions_total"),
+			"Number of normalized DN cache evictions",
+			nil,
+			nil,
+		),
+
+		size: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "ndn_cache", "size_bytes"),
+			"Current size of the normalized DN cache",
+			nil,
+			nil,
+		),
+
+		maxsize: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "ndn_cache", "max_size_bytes"),
+			"Maximum size of the normalized DN cache (nsslapd-ndn-cache-max-size)",
+			nil,
+			nil,
+		),
+
+		count: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "ndn_cache", "entries"),
+			"Number of DNs in the normalized DN cache",
+			nil,
+			nil,
+		),
+	}
+}
+
+// Describe sends the descriptors of the normalized DN cache metrics
+func (c *NDNCacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.tries
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.size
+	ch <- c.maxsize
+	ch <- c.count
+}
+
+// Collect reads the normalized DN cache statistics into Prometheus objects
+func (c *NDNCacheCollector) Collect(ch chan<- prometheus.Metric) {
+	conn, err := connect()
+	if err != nil {
+		log.WithError(err).Error("normalized DN cache scrape failed")
+		return
+	}
+	defer release(conn)
+
+	data, ok, err := getNDNCache(conn)
+	if err != nil {
+		log.WithError(err).Error("normalized DN cache scrape failed")
+		return
+	}
+	if !ok {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.tries, prometheus.CounterValue, data.tries)
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, data.hits)
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, data.misses)
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, data.evictions)
+	ch <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, data.size)
+	ch <- prometheus.MustNewConstMetric(c.maxsize, prometheus.GaugeValue, data.maxSize)
+	ch <- prometheus.MustNewConstMetric(c.count, prometheus.GaugeValue, data.count)
+}
+
+// getNDNCache reads the cache statistics from the ldbm database monitor. The
+// boolean result is false if the server does not publish them.
+func getNDNCache(conn *ldap.Conn) (ndnCacheData, bool, error) {
+	entries, err := searchEntries(conn, ldbmMonitorDN, ldap.ScopeBaseObject, "(objectclass=*)", []string{
+		"normalizeddncachetries",
+		"normalizeddncachehits",
+		"normalizeddncachemisses",
+		"normalizeddncacheevictions",
+		"currentnormalizeddncachesize",
+		"maxnormalizeddncachesize",
+		"currentnormalizeddncachecount",
+	})
+	if err != nil {
+		return ndnCacheData{}, false, err
+	}
+	if len(entries) == 0 {
+		return ndnCacheData{}, false, nil
+	}
+
+	entry := entries[0]
+	if entry.GetEqualFoldAttributeValue("normalizeddncachetries") == "" {
+		return ndnCacheData{}, false, nil
+	}
+
+	var data ndnCacheData
+	data.tries, _ = attributeFloat(entry, "normalizeddncachetries")
+	data.hits, _ = attributeFloat(entry, "normalizeddncachehits")
+	data.misses, _ = attributeFloat(entry, "normalizeddncachemisses")
+	data.evictions, _ = attributeFloat(entry, "normalizeddncacheevictions")
+	data.size, _ = attributeFloat(entry, "currentnormalizeddncachesize")
+	data.maxSize, _ = attributeFloat(entry, "maxnormalizeddncachesize")
+	data.count, _ = attributeFloat(entry, "currentnormalizeddncachecount")
+
+	return data, true, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// passwordExpiryBuckets are the upper bounds of the password expiry
+// histogram: already expired, within 7, 30 and 90 days
+var passwordExpiryBuckets = []float64{
+	0,
+	(7 * 24 * time.Hour).Seconds(),
+	(30 * 24 * time.Hour).Seconds(),
+	(90 * 24 * time.Hour).Seconds(),
+}
+
+// passwordExpiryStats stores the password expiry distribution of a subtree
+type passwordExpiryStats struct {
+	count        uint64
+	sum          float64
+	buckets      map[float64]uint64
+	neverExpires float64
+}
+
+// PasswordExpiryCollector exposes how soon the passwords below the configured
+// bases expire
+type PasswordExpiryCollector struct {
+	bases         []string
+	filter        string
+	serviceFilter string
+	cache         *metricCache
+	expiry        *prometheus.Desc
+	neverexpires  *prometheus.Desc
+}
+
+// NewPasswordExpiryCollector returns an initialized password expiry
+// collector. Accounts are selected with filter; accounts matching
+// serviceFilter are additionally reported with class="service". The searches
+// run in the background every interval.
+func NewPasswordExpiryCollector(bases []string, filter, serviceFilter string, interval time.Duration) *PasswordExpiryCollector {
+	c := &PasswordExpiryCollector{
+		bases:         bases,
+		filter:        filter,
+		serviceFilter: serviceFilter,
+
+		expiry: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "accounts", "password_expiry_seconds"),
+			"Time until passwordExpirationTime, negative if the password already expired",
+			[]string{"base", "class"},
+			nil,
+		),
+
+		neverexpires: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "accounts", "password_never_expires"),
+			"Number of accounts without passwordExpirationTime",
+			[]string{"base", "class"},
+			nil,
+		),
+	}
+	c.cache = newMetricCache("password expiry", interval, c.refresh)
+	return c
+}
+
+// Describe sends the descriptors of the password expiry metrics
+func (c *PasswordExpiryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.expiry
+	ch <- c.neverexpires
+}
+
+// Collect sends the password expiry metrics of the last background refresh
+func (c *PasswordExpiryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.cache.collect(ch)
+}
+
+// refresh searches the account bases into Prometheus objects
+func (c *PasswordExpiryCollector) refresh(conn *ldap.Conn) ([]prometheus.Metric, error) {
+	bases, err := searchBases(conn, c.bases)
+	if err != nil {
+		return nil, err
+	}
+
+	classes := map[string]string{"all": c.filter}
+	if c.serviceFilter != "" {
+		classes["service"] = c.serviceFilter
+	}
+
+	var metrics []prometheus.Metric
+	now := time.Now()
+	for _, base := range bases {
+		for class, filter := range classes {
+			stats, err := getPasswordExpiry(conn, base, filter, now)
+			if err != nil {
+				log.WithError(err).Errorf("password expiry refresh of %s failed", base)
+				continue
+			}
+
+			metrics = append(metrics,
+				prometheus.MustNewConstHistogram(c.expiry, stats.count, stats.sum, stats.buckets, base, class),
+				prometheus.MustNewConstMetric(c.neverexpires, prometheus.GaugeValue, stats.neverExpires, base, class),
+			)
+		}
+	}
+	return metrics, nil
+}
+
+// getPasswordExpiry builds the password expiry histogram of the entries
+// below base matching filter
+func getPasswordExpiry(conn *ldap.Conn, base, filter string, now time.Time) (passwordExpiryStats, error) {
+	stats := passwordExpiryStats{buckets: map[float64]uint64{}}
+	for _, bound := range passwordExpiryBuckets {
+		stats.buckets[bound] = 0
+	}
+
+	err := searchPaged(conn, base, ldap.ScopeWholeSubtree, filter, []string{"passwordExpirationTime"},
+		func(entry *ldap.Entry) {
+			expiration := entry.GetEqualFoldAttributeValue("passwordExpirationTime")
+			if expiration == "" {
+				stats.neverExpires++
+				return
+			}
+
+			t, err := parseGeneralizedTime(expiration)
+			if err != nil {
+				log.WithError(err).Errorf("invalid passwordExpirationTime in %s", entry.DN)
+				return
+			}
+
+			remaining := t.Sub(now).Seconds()
+			stats.count++
+			stats.sum += remaining
+			for _, bound := range passwordExpiryBuckets {
+				if remaining <= bound {
+					stats.buckets[bound]++
+				}
+			}
+		},
+	)
+
+	return stats, err
+}
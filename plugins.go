@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const pluginsDN = "cn=plugins,cn=config"
+
+// pluginData stores the state of a server plugin
+type pluginData struct {
+	name       string
+	pluginType string
+	version    string
+	enabled    bool
+}
+
+// PluginCollector exposes the plugin inventory and checks that the
+// required plugins are enabled
+type PluginCollector struct {
+	required []string
+	enabled  *prometheus.Desc
+	mismatch *prometheus.Desc
+}
+
+// NewPluginCollector returns an initialized plugin collector. required lists
+// the names of the plugins that must be enabled.
+func NewPluginCollector(required []string) *PluginCollector {
+	return &PluginCollector{
+		required: required,
+
+		enabled: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "plugin", "enabled"),
+			"Whether the plugin is enabled (nsslapd-pluginEnabled)",
+			[]string{"plugin", "type", "version"},
+			nil,
+		),
+
+		mismatch: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "plugin", "required_mismatch"),
+			"Whether a plugin that must be enabled is disabled or missing",
+			[]string{"plugin"},
+			nil,
+		),
+	}
+}
+
+// Describe sends the descriptors of the plugin metrics
+func (c *PluginCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.enabled
+	ch <- c.mismatch
+}
+
+// Collect reads the plugin entries into Prometheus objects
+func (c *PluginCollector) Collect(ch chan<- prometheus.Metric) {
+	conn, err := connect()
+	if err != nil {
+		log.WithError(err).Error("plugin scrape failed")
+		return
+	}
+	defer release(conn)
+
+	plugins, err := getPlugins(conn)
+	if err != nil {
+		log.WithError(err).Error("plugin scrape failed")
+		return
+	}
+
+	for _, plugin := range plugins {
+		enabled := 0.0
+		if plugin.enabled {
+			enabled = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.enabled, prometheus.GaugeValue, enabled, plugin.name, plugin.pluginType, plugin.version)
+	}
+
+	for _, name := range c.required {
+		mismatch := 1.0
+		for _, plugin := range plugins {
+			if strings.EqualFold(plugin.name, name) && plugin.enabled {
+				mismatch = 0
+				break
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(c.mismatch, prometheus.GaugeValue, mismatch, name)
+	}
+}
+
+func getPlugins(conn *ldap.Conn) ([]pluginData, error) {
+	entries, err := searchEntries(conn, pluginsDN, ldap.ScopeSingleLevel, "(objectclass=nsSlapdPlugin)",
+		[]string{"cn", "nsslapd-pluginEnabled", "nsslapd-pluginType", "nsslapd-pluginVersion"})
+	if err != nil {
+		return nil, err
+	}
+
+	plugins := make([]pluginData, 0, len(entries))
+	for _, entry := range entries {
+		plugins = append(plugins, pluginData{
+			name:       entry.GetEqualFoldAttributeValue("cn"),
+			pluginType: entry.GetEqualFoldAttributeValue("nsslapd-pluginType"),
+			version:    entry.GetEqualFoldAttributeValue("nsslapd-pluginVersion"),
+			enabled:    isTrue(entry.GetEqualFoldAttributeValue("nsslapd-pluginEnabled")),
+		})
+	}
+
+	return plugins, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Query types of user-defined searches
+const (
+	// queryCount exports the number of matching entries
+	queryCount = "count"
+	// queryValue exports a numeric attribute of every matching entry
+	queryValue = "value"
+	// queryValueCount exports the number of values of an attribute of every
+	// matching entry, e.g. the members of a group
+	queryValueCount = "value_count"
+)
+
+var (
+	// metricNameRE and labelNameRE are the valid Prometheus metric and label
+	// names
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// queriesConfig is the layout of the query configuration file
+type queriesConfig struct {
+	Queries []queryConfig `json:"queries"`
+}
+
+// queryConfig defines a user-defined search and how its result is exported
+type queryConfig struct {
+	Name      string            `json:"name"`
+	Help      string            `json:"help"`
+	Base      string            `json:"base"`
+	Scope     string            `json:"scope"`
+	Filter    string            `json:"filter"`
+	Type      string            `json:"type"`
+	Attribute string            `json:"attribute"`
+	Labels    map[string]string `json:"labels"`
+	Interval  string            `json:"interval"`
+}
+
+// query is a parsed queryConfig along with its cached result
+type query struct {
+	cfg        queryConfig
+	scope      int
+	interval   time.Duration
+	labelNames []string
+	desc       *prometheus.Desc
+	cache      *metricCache
+}
+
+// loadQueries reads and validates the query configuration file
+func loadQueries(path string) ([]*query, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg queriesConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	queries := make([]*query, 0, len(cfg.Queries))
+	names := map[string]bool{}
+	for _, qc := range cfg.Queries {
+		q, err := newQuery(qc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query %q: %w", qc.Name, err)
+		}
+		if names[qc.Name] {
+			return nil, fmt.Errorf("duplicate query %q", qc.Name)
+		}
+		names[qc.Name] = true
+		queries = append(queries, q)
+	}
+
+	return queries, nil
+}
+
+func newQuery(cfg queryConfig) (*query, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("missing name")
+	}
+	if !metricNameRE.MatchString(prometheus.BuildFQName(namespace, "query", cfg.Name)) {
+		return nil, fmt.Errorf("name may only contain letters, digits, underscores and colons")
+	}
+	if cfg.Filter == "" {
+		cfg.Filter = "(objectclass=*)"
+	}
+	if cfg.Help == "" {
+		cfg.Help = fmt.Sprintf("Result of the %s query", cfg.Name)
+	}
+
+	q := &query{cfg: cfg}
+
+	switch strings.ToLower(cfg.Scope) {
+	case "base":
+		q.scope = ldap.ScopeBaseObject
+	case "one":
+		q.scope = ldap.ScopeSingleLevel
+	case "", "sub":
+		q.scope = ldap.ScopeWholeSubtree
+	default:
+		return nil, fmt.Errorf("unknown scope %q", cfg.Scope)
+	}
+
+	switch cfg.Type {
+	case "", queryCount:
+		q.cfg.Type = queryCount
+	case queryValue, queryValueCount:
+		if cfg.Attribute == "" {
+			return nil, fmt.Errorf("type %s requires an attribute", cfg.Type)
+		}
+		// every matching entry becomes a metric of its own, so unless only
+		// the base entry matches the labels have to tell them apart
+		if len(cfg.Labels) == 0 && q.scope != ldap.ScopeBaseObject {
+			return nil, fmt.Errorf("type %s requires labels identifying the entries unless the scope is base", cfg.Type)
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %q", cfg.Type)
+	}
+
+	if cfg.Interval != "" {
+		interval, err := time.ParseDuration(cfg.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval: %w", err)
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("interval must be positive")
+		}
+		q.interval = interval
+	}
+
+	for label := range cfg.Labels {
+		if !labelNameRE.MatchString(label) || strings.HasPrefix(label, "__") {
+			return nil, fmt.Errorf("invalid label name %q", label)
+		}
+		q.labelNames = append(q.labelNames, label)
+	}
+	sort.Strings(q.labelNames)
+
+	q.desc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "query", cfg.Name),
+		cfg.Help,
+		q.labelNames,
+		nil,
+	)
+
+	return q, nil
+}
+
+// attributes returns the attributes the search has to request
+func (q *query) attributes() []string {
+	var attributes []string
+	if q.cfg.Attribute != "" {
+		attributes = append(attributes, q.cfg.Attribute)
+	}
+	for _, label := range q.labelNames {
+		if attribute := q.cfg.Labels[label]; !strings.EqualFold(attribute, "dn") {
+			attributes = append(attributes, attribute)
+		}
+	}
+	if len(attributes) == 0 {
+		// only count entries, do not transfer any attributes
+		attributes = append(attributes, "1.1")
+	}
+	return attributes
+}
+
+// labelValues extracts the label values of an entry. The pseudo attribute
+// "dn" is the DN of the entry.
+func (q *query) labelValues(entry *ldap.Entry) []string {
+	values := make([]string, 0, len(q.labelNames))
+	for _, label := range q.labelNames {
+		attribute := q.cfg.Labels[label]
+		if strings.EqualFold(attribute, "dn") {
+			values = append(values, entry.DN)
+		} else {
+			values = append(values, entry.GetEqualFoldAttributeValue(attribute))
+		}
+	}
+	return values
+}
+
+// validLabelValues reports whether values can be used as label values. Binary
+// attributes and values that are not valid UTF-8 are rejected by Prometheus.
+func validLabelValues(values []string) bool {
+	for _, v := range values {
+		if !utf8.ValidString(v) || strings.ContainsRune(v, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// run executes the search and converts the result into metrics
+func (q *query) run(conn *ldap.Conn) ([]prometheus.Metric, error) {
+	counts := map[string]float64{}
+	countLabels := map[string][]string{}
+	seen := map[string]bool{}
+	var metrics []prometheus.Metric
+
+	err := searchPaged(conn, q.cfg.Base, q.scope, q.cfg.Filter, q.attributes(), func(entry *ldap.Entry) {
+		labels := q.labelValues(entry)
+		if !validLabelValues(labels) {
+			log.Warnf("query %s: skipping %s, its labels %q are not valid UTF-8 text", q.cfg.Name, entry.DN, labels)
+			return
+		}
+		key := strings.Join(labels, "\x00")
+
+		// a duplicate label set would fail the whole scrape
+		if seen[key] {
+			log.Warnf("query %s: skipping %s, its labels %q are not unique", q.cfg.Name, entry.DN, labels)
+			return
+		}
+
+		switch q.cfg.Type {
+		case queryCount:
+			counts[key]++
+			countLabels[key] = labels
+		case queryValue:
+			value := entry.GetEqualFoldAttributeValue(q.cfg.Attribute)
+			if value == "" {
+				return
+			}
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				log.WithError(err).Errorf("invalid %s in %s", q.cfg.Attribute, entry.DN)
+				return
+			}
+			metrics = append(metrics, prometheus.MustNewConstMetric(q.desc, prometheus.GaugeValue, v, labels...))
+			seen[key] = true
+		case queryValueCount:
+			v := float64(len(entry.GetEqualFoldAttributeValues(q.cfg.Attribute)))
+			metrics = append(metrics, prometheus.MustNewConstMetric(q.desc, prometheus.GaugeValue, v, labels...))
+			seen[key] = true
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if q.cfg.Type == queryCount {
+		if len(q.labelNames) == 0 && len(counts) == 0 {
+			counts[""] = 0
+		}
+		for key, count := range counts {
+			metrics = append(metrics, prometheus.MustNewConstMetric(q.desc, prometheus.GaugeValue, count, countLabels[key]...))
+		}
+	}
+
+	return metrics, nil
+}
+
+// QueryCollector exports the results of user-defined searches
+type QueryCollector struct {
+	queries []*query
+}
+
+// NewQueryCollector returns a collector running the given queries in the
+// background, every query at its own interval or at defaultInterval
+func NewQueryCollector(queries []*query, defaultInterval time.Duration) *QueryCollector {
+	for _, q := range queries {
+		interval := q.interval
+		if interval == 0 {
+			interval = defaultInterval
+		}
+		q.cache = newMetricCache("query "+q.cfg.Name, interval, q.run)
+	}
+	return &QueryCollector{queries: queries}
+}
+
+// Describe sends the descriptors of the query metrics
+func (c *QueryCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, q := range c.queries {
+		ch <- q.desc
+	}
+}
+
+// Collect sends the cached results of all queries
+func (c *QueryCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, q := range c.queries {
+		q.cache.collect(ch)
+	}
+}
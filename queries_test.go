@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestNewQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     queryConfig
+		wantErr bool
+	}{
+		{"count", queryConfig{Name: "people", Base: "dc=example,dc=com"}, false},
+		{"missing name", queryConfig{Base: "dc=example,dc=com"}, true},
+		{"invalid name", queryConfig{Name: "people-count"}, true},
+		{"unknown scope", queryConfig{Name: "people", Scope: "tree"}, true},
+		{"value without attribute", queryConfig{Name: "people", Type: queryValue, Labels: map[string]string{"uid": "uid"}}, true},
+		{"value without labels", queryConfig{Name: "people", Type: queryValue, Attribute: "uidNumber"}, true},
+		{"value of base entry", queryConfig{Name: "members", Scope: "base", Type: queryValueCount, Attribute: "member"}, false},
+		{"invalid label", queryConfig{Name: "people", Labels: map[string]string{"group-name": "cn"}}, true},
+		{"reserved label", queryConfig{Name: "people", Labels: map[string]string{"__name": "cn"}}, true},
+		{"zero interval", queryConfig{Name: "people", Interval: "0s"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newQuery(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidLabelValues(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   bool
+	}{
+		{[]string{"cn=admins,dc=example,dc=com", "Müller"}, true},
+		{[]string{"ok", "ou=\xe9,dc=example"}, false},
+		{[]string{"binary\x00value"}, false},
+		{nil, true},
+	}
+
+	for _, tt := range tests {
+		if got := validLabelValues(tt.values); got != tt.want {
+			t.Errorf("validLabelValues(%q) = %v, want %v", tt.values, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// metricCache runs an expensive search in the background every interval and
+// keeps the resulting metrics, so that scrapes only read the cache and never
+// wait for a subtree scan
+type metricCache struct {
+	name     string
+	interval time.Duration
+	refresh  func(conn *ldap.Conn) ([]prometheus.Metric, error)
+
+	mu      sync.Mutex
+	metrics []prometheus.Metric
+}
+
+// newMetricCache returns a cache filled by refresh and starts refreshing it.
+// name is used in log messages.
+func newMetricCache(name string, interval time.Duration, refresh func(conn *ldap.Conn) ([]prometheus.Metric, error)) *metricCache {
+	c := &metricCache{
+		name:     name,
+		interval: interval,
+		refresh:  refresh,
+	}
+	go c.run()
+	return c
+}
+
+// run refreshes the cache right away and then every interval
+func (c *metricCache) run() {
+	for {
+		c.update()
+		time.Sleep(c.interval)
+	}
+}
+
+// update replaces the cached metrics. The previous metrics are kept if the
+// refresh fails.
+func (c *metricCache) update() {
+	conn, err := connect()
+	if err != nil {
+		log.WithError(err).Errorf("%s refresh failed", c.name)
+		return
+	}
+	defer release(conn)
+
+	metrics, err := c.refresh(conn)
+	if err != nil {
+		log.WithError(err).Errorf("%s refresh failed", c.name)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metrics = metrics
+}
+
+// collect sends the cached metrics
+func (c *metricCache) collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, m := range c.metrics {
+		ch <- m
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	retroChangelogPluginDN = "cn=Retro Changelog Plugin,cn=plugins,cn=config"
+	retroChangelogDN       = "cn=changelog"
+)
+
+// retroChangelogData stores the change number window of the retro changelog
+type retroChangelogData struct {
+	enabled           bool
+	hasChangeNumbers  bool
+	firstChangeNumber float64
+	lastChangeNumber  float64
+	maxAge            float64
+}
+
+// RetroChangelogCollector exposes the retro changelog change numbers
+type RetroChangelogCollector struct {
+	enabled           *prometheus.Desc
+	firstchangenumber *prometheus.Desc
+	lastchangenumber  *prometheus.Desc
+	maxage            *prometheus.Desc
+}
+
+// NewRetroChangelogCollector returns an initialized retro changelog collector
+func NewRetroChangelogCollector() *RetroChangelogCollector {
+	return &RetroChangelogCollector{
+		enabled: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "retrochangelog", "enabled"),
+			"Whether the Retro Changelog plugin is enabled",
+			nil,
+			nil,
+		),
+
+		firstchangenumber: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "retrochangelog", "first_change_number"),
+			"Oldest change number still present in the retro changelog",
+			nil,
+			nil,
+		),
+
+		lastchangenumber: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "retrochangelog", "last_change_number"),
+			"Newest change number in the retro changelog",
+			nil,
+			nil,
+		),
+
+		maxage: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "retrochangelog", "max_age_seconds"),
+			"Maximum age of retro changelog records before trimming, 0 if unlimited",
+			nil,
+			nil,
+		),
+	}
+}
+
+// Describe sends the descriptors of the retro changelog metrics
+func (c *RetroChangelogCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.enabled
+	ch <- c.firstchangenumber
+	ch <- c.lastchangenumber
+	ch <- c.maxage
+}
+
+// Collect reads the retro changelog state into Prometheus objects
+func (c *RetroChangelogCollector) Collect(ch chan<- prometheus.Metric) {
+	conn, err := connect()
+	if err != nil {
+		log.WithError(err).Error("retro changelog scrape failed")
+		return
+	}
+	defer release(conn)
+
+	data, err := getRetroChangelog(conn)
+	if err != nil {
+		log.WithError(err).Error("retro changelog scrape failed")
+		return
+	}
+
+	enabled := 0.0
+	if data.enabled {
+		enabled = 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.enabled, prometheus.GaugeValue, enabled)
+	if !data.enabled {
+		return
+	}
+
+	// missing change numbers are left out rather than reported as a reset
+	if data.hasChangeNumbers {
+		ch <- prometheus.MustNewConstMetric(c.firstchangenumber, prometheus.GaugeValue, data.firstChangeNumber)
+		ch <- prometheus.MustNewConstMetric(c.lastchangenumber, prometheus.GaugeValue, data.lastChangeNumber)
+	}
+	ch <- prometheus.MustNewConstMetric(c.maxage, prometheus.GaugeValue, data.maxAge)
+}
+
+// getRetroChangelog reads the change numbers from the root DSE, falling back
+// to the cn=changelog suffix when they are not published there
+func getRetroChangelog(conn *ldap.Conn) (retroChangelogData, error) {
+	var data retroChangelogData
+
+	plugins, err := searchEntries(conn, retroChangelogPluginDN, ldap.ScopeBaseObject, "(objectclass=*)",
+		[]string{"nsslapd-pluginEnabled", "nsslapd-changelogmaxage"})
+	if err != nil {
+		return data, err
+	}
+	if len(plugins) == 0 || !isTrue(plugins[0].GetEqualFoldAttributeValue("nsslapd-pluginEnabled")) {
+		return data, nil
+	}
+	data.enabled = true
+
+	if maxAge := plugins[0].GetEqualFoldAttributeValue("nsslapd-changelogmaxage"); maxAge != "" {
+		data.maxAge, err = parseAge(maxAge)
+		if err != nil {
+			log.WithError(err).Error("invalid retro changelog nsslapd-changelogmaxage")
+		}
+	}
+
+	attributes := []string{"firstchangenumber", "lastchangenumber"}
+	for _, base := range []string{"", retroChangelogDN} {
+		entries, err := searchEntries(conn, base, ldap.ScopeBaseObject, "(objectclass=*)", attributes)
+		if err != nil {
+			return data, err
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		first, firstOK := attributeFloat(entries[0], "firstchangenumber")
+		last, lastOK := attributeFloat(entries[0], "lastchangenumber")
+		if !firstOK || !lastOK {
+			continue
+		}
+		data.firstChangeNumber, data.lastChangeNumber = first, last
+		data.hasChangeNumbers = true
+		break
+	}
+
+	return data, nil
+}
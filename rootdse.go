@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// rootDSEData stores the server information published in the root DSE
+type rootDSEData struct {
+	vendorName            string
+	vendorVersion         string
+	supportedLDAPVersions []string
+	namingContexts        []string
+}
+
+// RootDSECollector exposes the root DSE as info metrics
+type RootDSECollector struct {
+	serverinfo        *prometheus.Desc
+	namingcontextinfo *prometheus.Desc
+}
+
+// NewRootDSECollector returns an initialized root DSE collector
+func NewRootDSECollector() *RootDSECollector {
+	return &RootDSECollector{
+		serverinfo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "server", "info"),
+			"Server vendor and version read from the root DSE",
+			[]string{"vendor_name", "vendor_version", "supported_ldap_version"},
+			nil,
+		),
+
+		namingcontextinfo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "naming_context", "info"),
+			"Naming contexts published in the root DSE",
+			[]string{"suffix"},
+			nil,
+		),
+	}
+}
+
+// Describe sends the descriptors of the root DSE metrics
+func (c *RootDSECollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.serverinfo
+	ch <- c.namingcontextinfo
+}
+
+// Collect reads the root DSE into Prometheus objects
+func (c *RootDSECollector) Collect(ch chan<- prometheus.Metric) {
+	conn, err := connect()
+	if err != nil {
+		log.WithError(err).Error("root DSE scrape failed")
+		return
+	}
+	defer release(conn)
+
+	data, err := getRootDSE(conn)
+	if err != nil {
+		log.WithError(err).Error("root DSE scrape failed")
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.serverinfo, prometheus.GaugeValue, 1,
+		data.vendorName, data.vendorVersion, strings.Join(data.supportedLDAPVersions, ","))
+	for _, suffix := range data.namingContexts {
+		ch <- prometheus.MustNewConstMetric(c.namingcontextinfo, prometheus.GaugeValue, 1, suffix)
+	}
+}
+
+func getRootDSE(conn *ldap.Conn) (rootDSEData, error) {
+	entries, err := searchEntries(conn, "", ldap.ScopeBaseObject, "(objectclass=*)",
+		[]string{"vendorName", "vendorVersion", "supportedLDAPVersion", "namingContexts"})
+	if err != nil {
+		return rootDSEData{}, err
+	}
+	if len(entries) == 0 {
+		return rootDSEData{}, fmt.Errorf("root DSE is not readable")
+	}
+
+	entry := entries[0]
+	return rootDSEData{
+		vendorName:            entry.GetEqualFoldAttributeValue("vendorName"),
+		vendorVersion:         entry.GetEqualFoldAttributeValue("vendorVersion"),
+		supportedLDAPVersions: entry.GetEqualFoldAttributeValues("supportedLDAPVersion"),
+		namingContexts:        entry.GetEqualFoldAttributeValues("namingContexts"),
+	}, nil
+}
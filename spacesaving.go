@@ -0,0 +1,171 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// topEntry is a key tracked by a spaceSaving sketch. value overestimates the
+// true total of the key by at most maxError.
+type topEntry struct {
+	Name     string  `json:"name"`
+	Value    float64 `json:"value"`
+	MaxError float64 `json:"max_error"`
+}
+
+// spaceSaving is a space-saving sketch keeping the approximate totals of the
+// k heaviest keys of a stream in constant memory. A key that is not tracked
+// replaces the lightest one and inherits its total as the error bound.
+type spaceSaving struct {
+	k       int
+	entries map[string]*topEntry
+}
+
+func newSpaceSaving(k int) *spaceSaving {
+	return &spaceSaving{
+		k:       k,
+		entries: map[string]*topEntry{},
+	}
+}
+
+// add adds weight to the total of key
+func (s *spaceSaving) add(key string, weight float64) {
+	if e, ok := s.entries[key]; ok {
+		e.Value += weight
+		return
+	}
+	if s.k <= 0 {
+		return
+	}
+	if len(s.entries) < s.k {
+		s.entries[key] = &topEntry{Name: key, Value: weight}
+		return
+	}
+
+	// ties are broken by name so that evictions do not depend on the map
+	// order
+	var min *topEntry
+	for _, e := range s.entries {
+		if min == nil || e.Value < min.Value || e.Value == min.Value && e.Name < min.Name {
+			min = e
+		}
+	}
+	delete(s.entries, min.Name)
+	s.entries[key] = &topEntry{Name: key, Value: min.Value + weight, MaxError: min.Value}
+}
+
+// top returns the tracked keys, heaviest first
+func (s *spaceSaving) top() []topEntry {
+	entries := make([]topEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Value != entries[j].Value {
+			return entries[i].Value > entries[j].Value
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+// overflowLabel is the label value of the series counting everything not
+// attributed to a tracked label set
+const overflowLabel = "other"
+
+// topCounter counts label sets in a spaceSaving sketch and exports the k
+// most frequent ones. The number of series stays bounded while a new heavy
+// hitter still displaces the least frequent label set, which a counter vector
+// with a fixed set of admitted label values cannot do.
+//
+// A label set that displaces another inherits its count as error, so every
+// series only reports the count the label set is guaranteed to have, value
+// minus error. The rest of the total goes to a series with every label set to
+// overflowLabel. That way the series add up to the true total, and spraying
+// many distinct label sets shows up in the overflow series instead of
+// inflating the tracked ones.
+type topCounter struct {
+	mu     sync.Mutex
+	sketch *spaceSaving
+	total  float64
+	desc   *prometheus.Desc
+	// overflowKey is the key of the overflow series
+	overflowKey string
+}
+
+// newTopCounter returns a counter of the k most frequent label sets exported
+// as the counter name
+func newTopCounter(k int, name, help string, labelNames ...string) *topCounter {
+	overflow := make([]string, len(labelNames))
+	for i := range overflow {
+		overflow[i] = overflowLabel
+	}
+	return &topCounter{
+		sketch:      newSpaceSaving(k),
+		desc:        prometheus.NewDesc(name, help, labelNames, nil),
+		overflowKey: strings.Join(overflow, "\x00"),
+	}
+}
+
+// inc counts one occurrence of a label set. Label values come from the
+// access log and are cleaned up to valid UTF-8, since Prometheus fails the
+// whole scrape on anything else.
+func (c *topCounter) inc(labels ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, l := range labels {
+		labels[i] = strings.ToValidUTF8(l, "\uFFFD")
+	}
+
+	c.total++
+	key := strings.Join(labels, "\x00")
+	// only counted in the overflow series, which would otherwise be
+	// exported twice
+	if key == c.overflowKey {
+		return
+	}
+	c.sketch.add(key, 1)
+}
+
+// rest returns the count not attributed to a tracked label set. It must be
+// called with mu held.
+func (c *topCounter) rest() float64 {
+	rest := c.total
+	for _, e := range c.sketch.entries {
+		rest -= e.Value - e.MaxError
+	}
+	return rest
+}
+
+// value returns the exported count of a label set, 0 if it is not tracked
+func (c *topCounter) value(labels ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := strings.Join(labels, "\x00")
+	if key == c.overflowKey {
+		return c.rest()
+	}
+	if e, ok := c.sketch.entries[key]; ok {
+		return e.Value - e.MaxError
+	}
+	return 0
+}
+
+func (c *topCounter) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *topCounter) collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range c.sketch.top() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, e.Value-e.MaxError, strings.Split(e.Name, "\x00")...)
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, c.rest(), strings.Split(c.overflowKey, "\x00")...)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSpaceSaving(t *testing.T) {
+	s := newSpaceSaving(2)
+	for _, key := range []string{"a", "a", "a", "b", "c", "c", "c", "c"} {
+		s.add(key, 1)
+	}
+
+	// c replaces b, the lightest key, and inherits its count as error
+	want := []topEntry{
+		{Name: "c", Value: 5, MaxError: 1},
+		{Name: "a", Value: 3},
+	}
+	if got := s.top(); !reflect.DeepEqual(got, want) {
+		t.Errorf("top() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSpaceSavingDisabled(t *testing.T) {
+	s := newSpaceSaving(0)
+	s.add("a", 1)
+	if got := s.top(); len(got) != 0 {
+		t.Errorf("top() = %+v, want nothing", got)
+	}
+}
+
+// topCounterCollector registers a topCounter for testutil
+type topCounterCollector struct {
+	*topCounter
+}
+
+func (c topCounterCollector) Describe(ch chan<- *prometheus.Desc) { c.describe(ch) }
+func (c topCounterCollector) Collect(ch chan<- prometheus.Metric) { c.collect(ch) }
+
+func TestTopCounterDisplacesStaleEntries(t *testing.T) {
+	c := newTopCounter(3, "failed_binds_total", "Failed binds", "dn")
+
+	// a spraying run fills every slot with a single failure each
+	for _, dn := range []string{"uid=a", "uid=b", "uid=c", "uid=d"} {
+		c.inc(dn)
+	}
+	// a service account with a stale password keeps failing afterwards
+	for i := 0; i < 5; i++ {
+		c.inc("uid=service")
+	}
+
+	// uid=service replaced uid=b and inherited its single failure as error
+	if got, want := *c.sketch.entries["uid=service"], (topEntry{Name: "uid=service", Value: 6, MaxError: 1}); got != want {
+		t.Errorf("uid=service entry = %+v, want %+v", got, want)
+	}
+
+	// uid=a and uid=b were evicted, their failures are counted as other
+	want := `
+# HELP failed_binds_total Failed binds
+# TYPE failed_binds_total counter
+failed_binds_total{dn="other"} 2
+failed_binds_total{dn="uid=c"} 1
+failed_binds_total{dn="uid=d"} 1
+failed_binds_total{dn="uid=service"} 5
+`
+	if err := testutil.CollectAndCompare(topCounterCollector{c}, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTopCounterSpraying(t *testing.T) {
+	c := newTopCounter(100, "failed_binds_total", "Failed binds", "dn")
+
+	// every DN fails exactly once
+	for i := 0; i < 10000; i++ {
+		c.inc(fmt.Sprintf("uid=user%d", i))
+	}
+
+	for _, e := range c.sketch.top() {
+		if got := c.value(e.Name); got != 1 {
+			t.Errorf("%s = %v with error %v, want 1", e.Name, got, e.MaxError)
+		}
+	}
+	if got := c.value(overflowLabel); got != 9900 {
+		t.Errorf("other = %v, want 9900", got)
+	}
+}
+
+func TestTopCounterOverflowLabel(t *testing.T) {
+	c := newTopCounter(2, "failed_binds_total", "Failed binds", "dn")
+	c.inc("uid=a")
+	c.inc(overflowLabel)
+
+	if got := len(c.sketch.entries); got != 1 {
+		t.Errorf("tracked %d label sets, want 1", got)
+	}
+	if got := c.value(overflowLabel); got != 1 {
+		t.Errorf("other = %v, want 1", got)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// resolveAccessLogPath expands a glob like /var/log/dirsrv/slapd-*/access,
+// which has to match exactly one file
+func resolveAccessLogPath(pattern string) (string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return "", err
+	}
+	if len(matches) != 1 {
+		return "", fmt.Errorf("%s matches %d files, expected exactly one", pattern, len(matches))
+	}
+	return matches[0], nil
+}
+
+// tailPosition is the position of the tailer persisted across restarts: the
+// inode of the followed file and the offset behind the last complete line
+type tailPosition struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+// tailer follows a growing file and hands every complete line to a callback.
+// It follows the file across rotation, when the server renames it to
+// access.YYYYMMDD-hhmmss and creates a new one, and across truncation. With a
+// position file it resumes where it stopped after a restart; lines handled
+// in the last poll interval before a crash may be handled twice.
+type tailer struct {
+	path         string
+	poll         time.Duration
+	positionFile string
+	drainRotated bool
+
+	file    *os.File
+	info    os.FileInfo
+	reader  *bufio.Reader
+	pos     tailPosition
+	partial string
+	opened  bool
+	saved   tailPosition
+}
+
+func newTailer(path string, poll time.Duration, positionFile string, drainRotated bool) *tailer {
+	return &tailer{
+		path:         path,
+		poll:         poll,
+		positionFile: positionFile,
+		drainRotated: drainRotated,
+	}
+}
+
+// run follows the file and never returns
+func (t *tailer) run(fn func(string)) {
+	for {
+		if err := t.step(fn); err != nil {
+			log.WithError(err).Errorf("failed to follow %s", t.path)
+			t.close()
+		}
+		time.Sleep(t.poll)
+	}
+}
+
+// step hands the lines written since the last step to fn, switches to a new
+// file after rotation, starts over after truncation and saves the position
+func (t *tailer) step(fn func(string)) error {
+	if t.file == nil {
+		if err := t.open(fn); err != nil {
+			return err
+		}
+	}
+
+	fi, err := os.Stat(t.path)
+	switch {
+	case os.IsNotExist(err):
+		// rotated, but the new file has not been created yet
+		if !t.drainRotated {
+			return nil
+		}
+	case err != nil:
+		return err
+	case !os.SameFile(fi, t.info):
+		log.Infof("%s was rotated", t.path)
+		if t.drainRotated {
+			if err := t.read(fn); err != nil {
+				return err
+			}
+			t.flush(fn)
+		}
+		t.close()
+		if err := t.openAt(0); err != nil {
+			return err
+		}
+	case fi.Size() < t.pos.Offset+int64(len(t.partial)):
+		// a file truncated and refilled beyond the old offset within one
+		// poll interval looks like a growing file
+		log.Infof("%s was truncated", t.path)
+		if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		t.reader.Reset(t.file)
+		t.pos.Offset = 0
+		t.partial = ""
+	}
+
+	if err := t.read(fn); err != nil {
+		return err
+	}
+	if err := t.savePosition(); err != nil {
+		log.WithError(err).Errorf("failed to save tail position to %s", t.positionFile)
+	}
+	return nil
+}
+
+// open opens the file for the first time or after an error. It continues at
+// the last known position, either from memory or from the position file, and
+// starts at the end of the file if there is none.
+func (t *tailer) open(fn func(string)) error {
+	pos, ok := t.pos, t.opened
+	if !ok {
+		var err error
+		pos, ok, err = t.loadPosition()
+		if err != nil {
+			log.WithError(err).Errorf("failed to read tail position from %s", t.positionFile)
+		}
+	}
+
+	fi, err := os.Stat(t.path)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !ok:
+		return t.openAt(fi.Size())
+	case pos.Inode == fileInode(fi) && pos.Offset <= fi.Size():
+		return t.openAt(pos.Offset)
+	case pos.Inode == fileInode(fi):
+		log.Infof("%s was truncated", t.path)
+		return t.openAt(0)
+	default:
+		log.Infof("%s was rotated", t.path)
+		if t.drainRotated {
+			t.drain(pos, fn)
+		}
+		return t.openAt(0)
+	}
+}
+
+// openAt opens the file and positions it at offset
+func (t *tailer) openAt(offset int64) error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	t.file = f
+	t.info = fi
+	t.reader = bufio.NewReaderSize(f, 64*1024)
+	t.pos = tailPosition{Inode: fileInode(fi), Offset: offset}
+	t.partial = ""
+	t.opened = true
+	return nil
+}
+
+func (t *tailer) close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
+
+// read hands every complete line up to the end of the file to fn and keeps
+// an incomplete line until the server finishes writing it
+func (t *tailer) read(fn func(string)) error {
+	for {
+		line, err := t.reader.ReadString('\n')
+		t.partial += line
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		t.pos.Offset += int64(len(t.partial))
+		fn(t.partial)
+		t.partial = ""
+	}
+}
+
+// flush hands an incomplete last line of a rotated file to fn, since the
+// server will not complete it anymore
+func (t *tailer) flush(fn func(string)) {
+	if t.partial != "" {
+		t.pos.Offset += int64(len(t.partial))
+		fn(t.partial)
+		t.partial = ""
+	}
+}
+
+// drain hands the rest of the file the position refers to to fn, if it was
+// rotated while the exporter was not running and is still next to the log
+func (t *tailer) drain(pos tailPosition, fn func(string)) {
+	matches, err := filepath.Glob(t.path + ".*")
+	if err != nil {
+		log.WithError(err).Errorf("failed to look for rotated %s", t.path)
+		return
+	}
+
+	for _, path := range matches {
+		fi, err := os.Stat(path)
+		if err != nil || fileInode(fi) != pos.Inode || fi.Size() < pos.Offset {
+			continue
+		}
+
+		log.Infof("Draining rotated access log %s", path)
+		rotated := newTailer(path, t.poll, "", false)
+		if err := rotated.openAt(pos.Offset); err != nil {
+			log.WithError(err).Errorf("failed to drain %s", path)
+			return
+		}
+		defer rotated.close()
+		if err := rotated.read(fn); err != nil {
+			log.WithError(err).Errorf("failed to drain %s", path)
+		}
+		rotated.flush(fn)
+		return
+	}
+
+	log.Warnf("rotated %s not found, lines written while the exporter was stopped are skipped", t.path)
+}
+
+// loadPosition reads the position file. The boolean result is false if there
+// is no position file or no position has been saved yet.
+func (t *tailer) loadPosition() (tailPosition, bool, error) {
+	var pos tailPosition
+	if t.positionFile == "" {
+		return pos, false, nil
+	}
+
+	data, err := os.ReadFile(t.positionFile)
+	if os.IsNotExist(err) {
+		return pos, false, nil
+	}
+	if err != nil {
+		return pos, false, err
+	}
+	if err := json.Unmarshal(data, &pos); err != nil {
+		return pos, false, fmt.Errorf("failed to parse %s: %w", t.positionFile, err)
+	}
+	t.saved = pos
+	return pos, true, nil
+}
+
+// savePosition writes the position file if the position changed. The file
+// is replaced atomically so a crash never leaves a partial position.
+func (t *tailer) savePosition() error {
+	if t.positionFile == "" || t.pos == t.saved {
+		return nil
+	}
+
+	data, err := json.Marshal(t.pos)
+	if err != nil {
+		return err
+	}
+	tmp := t.positionFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, t.positionFile); err != nil {
+		return err
+	}
+	t.saved = t.pos
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import "os"
+
+// fileInode returns 0 on platforms without inode numbers. A persisted
+// position is then resumed by its offset alone.
+func fileInode(fi os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// tailTest follows an access log in a temporary directory and records the
+// lines handed to the parser
+type tailTest struct {
+	t        *testing.T
+	path     string
+	position string
+	lines    []string
+}
+
+func newTailTest(t *testing.T) *tailTest {
+	dir := t.TempDir()
+	return &tailTest{
+		t:        t,
+		path:     filepath.Join(dir, "access"),
+		position: filepath.Join(dir, "access.position"),
+	}
+}
+
+// write appends s to the file at path, creating it if needed
+func (tt *tailTest) write(path, s string) {
+	tt.t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		tt.t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(s); err != nil {
+		tt.t.Fatal(err)
+	}
+}
+
+// rotate renames the log like the server does and returns the new name
+func (tt *tailTest) rotate(suffix string) string {
+	tt.t.Helper()
+	rotated := tt.path + "." + suffix
+	if err := os.Rename(tt.path, rotated); err != nil {
+		tt.t.Fatal(err)
+	}
+	return rotated
+}
+
+func (tt *tailTest) step(tl *tailer) {
+	tt.t.Helper()
+	if err := tl.step(func(line string) { tt.lines = append(tt.lines, line) }); err != nil {
+		tt.t.Fatal(err)
+	}
+}
+
+func (tt *tailTest) expect(want ...string) {
+	tt.t.Helper()
+	if !reflect.DeepEqual(tt.lines, want) {
+		tt.t.Errorf("lines = %q, want %q", tt.lines, want)
+	}
+}
+
+func (tt *tailTest) skipWithoutInodes() {
+	tt.t.Helper()
+	fi, err := os.Stat(tt.path)
+	if err != nil {
+		tt.t.Fatal(err)
+	}
+	if fileInode(fi) == 0 {
+		tt.t.Skip("no inode numbers on this platform")
+	}
+}
+
+func TestTailerAppend(t *testing.T) {
+	tt := newTailTest(t)
+	tt.write(tt.path, "before start\n")
+	tl := newTailer(tt.path, 0, "", true)
+	defer tl.close()
+
+	tt.step(tl)
+	tt.write(tt.path, "a\nb")
+	tt.step(tl)
+	tt.write(tt.path, "c\n")
+	tt.step(tl)
+
+	tt.expect("a\n", "bc\n")
+}
+
+func TestTailerRotation(t *testing.T) {
+	for _, drain := range []bool{true, false} {
+		tt := newTailTest(t)
+		tt.write(tt.path, "")
+		tl := newTailer(tt.path, 0, "", drain)
+
+		tt.step(tl)
+		tt.write(tt.path, "a\n")
+		tt.step(tl)
+		rotated := tt.rotate("20211018-120000")
+		// written by the server before it switched to the new file
+		tt.write(rotated, "b\n")
+		tt.write(tt.path, "c\n")
+		tt.step(tl)
+		tt.write(tt.path, "d\n")
+		tt.step(tl)
+		tl.close()
+
+		if drain {
+			tt.expect("a\n", "b\n", "c\n", "d\n")
+		} else {
+			tt.expect("a\n", "c\n", "d\n")
+		}
+	}
+}
+
+func TestTailerRotationPartialLine(t *testing.T) {
+	tt := newTailTest(t)
+	tt.write(tt.path, "")
+	tl := newTailer(tt.path, 0, "", true)
+	defer tl.close()
+
+	tt.step(tl)
+	tt.write(tt.path, "a\npart")
+	tt.step(tl)
+	rotated := tt.rotate("20211018-120000")
+	tt.write(rotated, "ial\nlast")
+	tt.write(tt.path, "b\n")
+	tt.step(tl)
+
+	tt.expect("a\n", "partial\n", "last", "b\n")
+}
+
+func TestTailerRotationBeforeNewFile(t *testing.T) {
+	tt := newTailTest(t)
+	tt.write(tt.path, "")
+	tl := newTailer(tt.path, 0, "", true)
+	defer tl.close()
+
+	tt.step(tl)
+	tt.write(tt.path, "a\n")
+	tt.rotate("20211018-120000")
+	tt.step(tl)
+	tt.write(tt.path, "b\n")
+	tt.step(tl)
+
+	tt.expect("a\n", "b\n")
+}
+
+func TestTailerTruncation(t *testing.T) {
+	tt := newTailTest(t)
+	tt.write(tt.path, "")
+	tl := newTailer(tt.path, 0, "", true)
+	defer tl.close()
+
+	tt.step(tl)
+	tt.write(tt.path, "first line\nsecond line\n")
+	tt.step(tl)
+	if err := os.Truncate(tt.path, 0); err != nil {
+		t.Fatal(err)
+	}
+	tt.write(tt.path, "a\n")
+	tt.step(tl)
+
+	tt.expect("first line\n", "second line\n", "a\n")
+}
+
+func TestTailerResume(t *testing.T) {
+	tt := newTailTest(t)
+	tt.write(tt.path, "before start\n")
+
+	tl := newTailer(tt.path, 0, tt.position, true)
+	tt.step(tl)
+	tt.write(tt.path, "a\n")
+	tt.step(tl)
+	tl.close()
+
+	// written while the exporter is stopped
+	tt.write(tt.path, "b\n")
+
+	tl = newTailer(tt.path, 0, tt.position, true)
+	defer tl.close()
+	tt.step(tl)
+
+	tt.expect("a\n", "b\n")
+}
+
+func TestTailerResumeAfterRotation(t *testing.T) {
+	tt := newTailTest(t)
+	tt.write(tt.path, "")
+	tt.skipWithoutInodes()
+
+	tl := newTailer(tt.path, 0, tt.position, true)
+	tt.step(tl)
+	tt.write(tt.path, "a\n")
+	tt.step(tl)
+	tl.close()
+
+	// the log is rotated while the exporter is stopped
+	tt.write(tt.path, "b\n")
+	tt.rotate("20211018-120000")
+	tt.write(tt.path, "c\n")
+
+	tl = newTailer(tt.path, 0, tt.position, true)
+	defer tl.close()
+	tt.step(tl)
+
+	tt.expect("a\n", "b\n", "c\n")
+}
+
+func TestTailerResumeRotatedFileMissing(t *testing.T) {
+	tt := newTailTest(t)
+	tt.write(tt.path, "")
+	tt.skipWithoutInodes()
+
+	tl := newTailer(tt.path, 0, tt.position, true)
+	tt.step(tl)
+	tt.write(tt.path, "a\n")
+	tt.step(tl)
+	tl.close()
+
+	// rotated and removed, e.g. by nsslapd-accesslog-maxlogsperdir. The
+	// new file is created first so that it cannot reuse the inode.
+	tt.write(tt.path, "b\n")
+	rotated := tt.rotate("20211018-120000")
+	tt.write(tt.path, "c\n")
+	if err := os.Remove(rotated); err != nil {
+		t.Fatal(err)
+	}
+
+	tl = newTailer(tt.path, 0, tt.position, true)
+	defer tl.close()
+	tt.step(tl)
+
+	tt.expect("a\n", "c\n")
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const tasksDN = "cn=tasks,cn=config"
+
+// taskData stores the state of a server task
+type taskData struct {
+	taskType     string
+	name         string
+	currentItems float64
+	totalItems   float64
+	hasTotal     bool
+	exitCode     float64
+	finished     bool
+}
+
+// TaskCollector exposes the progress of the server tasks in cn=tasks,cn=config
+type TaskCollector struct {
+	running      *prometheus.Desc
+	progress     *prometheus.Desc
+	currentitems *prometheus.Desc
+	totalitems   *prometheus.Desc
+	exitcode     *prometheus.Desc
+}
+
+// NewTaskCollector returns an initialized task collector
+func NewTaskCollector() *TaskCollector {
+	labels := []string{"type", "task"}
+
+	return &TaskCollector{
+		running: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "task", "running"),
+			"Whether the task is still running",
+			labels,
+			nil,
+		),
+
+		progress: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "task", "progress_ratio"),
+			"Progress of the task (nsTaskCurrentItem / nsTaskTotalItems)",
+			labels,
+			nil,
+		),
+
+		currentitems: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "task", "current_items"),
+			"Number of items the task has processed (nsTaskCurrentItem)",
+			labels,
+			nil,
+		),
+
+		totalitems: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "task", "total_items"),
+			"Number of items the task has to process (nsTaskTotalItems)",
+			labels,
+			nil,
+		),
+
+		exitcode: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "task", "exit_code"),
+			"Exit code of the finished task (nsTaskExitCode)",
+			labels,
+			nil,
+		),
+	}
+}
+
+// Describe sends the descriptors of the task metrics
+func (c *TaskCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.running
+	ch <- c.progress
+	ch <- c.currentitems
+	ch <- c.totalitems
+	ch <- c.exitcode
+}
+
+// Collect reads the task entries into Prometheus objects
+func (c *TaskCollector) Collect(ch chan<- prometheus.Metric) {
+	conn, err := connect()
+	if err != nil {
+		log.WithError(err).Error("task scrape failed")
+		return
+	}
+	defer release(conn)
+
+	tasks, err := getTasks(conn)
+	if err != nil {
+		log.WithError(err).Error("task scrape failed")
+		return
+	}
+
+	for _, task := range tasks {
+		running := 1.0
+		if task.finished {
+			running = 0
+			ch <- prometheus.MustNewConstMetric(c.exitcode, prometheus.GaugeValue, task.exitCode, task.taskType, task.name)
+		}
+		ch <- prometheus.MustNewConstMetric(c.running, prometheus.GaugeValue, running, task.taskType, task.name)
+		ch <- prometheus.MustNewConstMetric(c.currentitems, prometheus.GaugeValue, task.currentItems, task.taskType, task.name)
+
+		if task.hasTotal {
+			ch <- prometheus.MustNewConstMetric(c.totalitems, prometheus.GaugeValue, task.totalItems, task.taskType, task.name)
+			if task.totalItems > 0 {
+				ch <- prometheus.MustNewConstMetric(c.progress, prometheus.GaugeValue, task.currentItems/task.totalItems, task.taskType, task.name)
+			}
+		}
+	}
+}
+
+// getTasks lists the task entries, which are stored as
+// cn=<task>,cn=<type>,cn=tasks,cn=config
+func getTasks(conn *ldap.Conn) ([]taskData, error) {
+	entries, err := searchEntries(conn, tasksDN, ldap.ScopeWholeSubtree, "(objectclass=*)",
+		[]string{"cn", "nsTaskCurrentItem", "nsTaskTotalItems", "nsTaskExitCode"})
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks []taskData
+	for _, entry := range entries {
+		dn, err := ldap.ParseDN(entry.DN)
+		if err != nil {
+			log.WithError(err).Errorf("invalid task DN %s", entry.DN)
+			continue
+		}
+		if len(dn.RDNs) != 4 {
+			continue
+		}
+
+		task := taskData{
+			taskType: strings.ToLower(dn.RDNs[1].Attributes[0].Value),
+			name:     dn.RDNs[0].Attributes[0].Value,
+		}
+		task.currentItems, _ = attributeFloat(entry, "nsTaskCurrentItem")
+		task.totalItems, task.hasTotal = attributeFloat(entry, "nsTaskTotalItems")
+		task.exitCode, task.finished = attributeFloat(entry, "nsTaskExitCode")
+
+		tasks = append(tasks, task)
+	}
+
+	return tasks, nil
+}
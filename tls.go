@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/x509"
+
+	"github.com/go-ldap/ldap/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+const encryptionRSADN = "cn=RSA,cn=encryption,cn=config"
+
+// tlsData stores the certificate chain presented by the server during the
+// TLS handshake and the configured server certificate nickname
+type tlsData struct {
+	enabled      bool
+	certificates []*x509.Certificate
+	personality  string
+}
+
+// getTLSData reads the peer certificates of an LDAPS or StartTLS connection
+func getTLSData(conn *ldap.Conn) tlsData {
+	state, ok := conn.TLSConnectionState()
+	if !ok {
+		return tlsData{}
+	}
+
+	data := tlsData{
+		enabled:      true,
+		certificates: state.PeerCertificates,
+	}
+
+	entries, err := searchEntries(conn, encryptionRSADN, ldap.ScopeBaseObject, "(objectclass=*)", []string{"nsSSLPersonalitySSL"})
+	if err != nil {
+		log.WithError(err).Error("failed to read server certificate nickname")
+	}
+	for _, entry := range entries {
+		data.personality = entry.GetEqualFoldAttributeValue("nsSSLPersonalitySSL")
+	}
+
+	return data
+}
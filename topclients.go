@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// topClientsListing is the layout of the top clients API
+type topClientsListing struct {
+	ClientsByOperations []topEntry `json:"clients_by_operations"`
+	ClientsByDuration   []topEntry `json:"clients_by_duration"`
+	BindDNsByOperations []topEntry `json:"bind_dns_by_operations"`
+	BindDNsByDuration   []topEntry `json:"bind_dns_by_duration"`
+}
+
+// topClients tracks the client addresses and bind DNs causing the most
+// operations and the most total etime
+type topClients struct {
+	mu                  sync.Mutex
+	clientsByOperations *spaceSaving
+	clientsByDuration   *spaceSaving
+	bindDNsByOperations *spaceSaving
+	bindDNsByDuration   *spaceSaving
+
+	clientoperations *prometheus.Desc
+	clientduration   *prometheus.Desc
+	bindoperations   *prometheus.Desc
+	bindduration     *prometheus.Desc
+}
+
+func newTopClients(k int) *topClients {
+	return &topClients{
+		clientsByOperations: newSpaceSaving(k),
+		clientsByDuration:   newSpaceSaving(k),
+		bindDNsByOperations: newSpaceSaving(k),
+		bindDNsByDuration:   newSpaceSaving(k),
+
+		clientoperations: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "top_client", "operations"),
+			"Approximate number of operations of the busiest client addresses in the access log",
+			[]string{"client"},
+			nil,
+		),
+
+		clientduration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "top_client", "duration_seconds"),
+			"Approximate total etime of the operations of the busiest client addresses in the access log",
+			[]string{"client"},
+			nil,
+		),
+
+		bindoperations: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "top_bind_dn", "operations"),
+			"Approximate number of operations of the busiest bind DNs in the access log",
+			[]string{"dn"},
+			nil,
+		),
+
+		bindduration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "top_bind_dn", "duration_seconds"),
+			"Approximate total etime of the operations of the busiest bind DNs in the access log",
+			[]string{"dn"},
+			nil,
+		),
+	}
+}
+
+// addOperation counts an operation of a client and bind DN. Both are label
+// values, so they are cleaned up to valid UTF-8 like those of topCounter.
+func (t *topClients) addOperation(client, dn string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.clientsByOperations.add(strings.ToValidUTF8(client, "\uFFFD"), 1)
+	t.bindDNsByOperations.add(strings.ToValidUTF8(dn, "\uFFFD"), 1)
+}
+
+// addDuration adds the etime of an operation of a client and bind DN
+func (t *topClients) addDuration(client, dn string, seconds float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.clientsByDuration.add(strings.ToValidUTF8(client, "\uFFFD"), seconds)
+	t.bindDNsByDuration.add(strings.ToValidUTF8(dn, "\uFFFD"), seconds)
+}
+
+func (t *topClients) list() topClientsListing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return topClientsListing{
+		ClientsByOperations: t.clientsByOperations.top(),
+		ClientsByDuration:   t.clientsByDuration.top(),
+		BindDNsByOperations: t.bindDNsByOperations.top(),
+		BindDNsByDuration:   t.bindDNsByDuration.top(),
+	}
+}
+
+func (t *topClients) describe(ch chan<- *prometheus.Desc) {
+	ch <- t.clientoperations
+	ch <- t.clientduration
+	ch <- t.bindoperations
+	ch <- t.bindduration
+}
+
+// collect sends the tracked keys as gauges, since keys come and go as the
+// sketch evicts them
+func (t *topClients) collect(ch chan<- prometheus.Metric) {
+	listing := t.list()
+	for _, e := range listing.ClientsByOperations {
+		ch <- prometheus.MustNewConstMetric(t.clientoperations, prometheus.GaugeValue, e.Value, e.Name)
+	}
+	for _, e := range listing.ClientsByDuration {
+		ch <- prometheus.MustNewConstMetric(t.clientduration, prometheus.GaugeValue, e.Value, e.Name)
+	}
+	for _, e := range listing.BindDNsByOperations {
+		ch <- prometheus.MustNewConstMetric(t.bindoperations, prometheus.GaugeValue, e.Value, e.Name)
+	}
+	for _, e := range listing.BindDNsByDuration {
+		ch <- prometheus.MustNewConstMetric(t.bindduration, prometheus.GaugeValue, e.Value, e.Name)
+	}
+}
+
+// handler serves the top clients as JSON
+func (t *topClients) handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(t.list()); err != nil {
+			log.WithError(err).Error("failed to write top clients listing")
+		}
+	}
+}
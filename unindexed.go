@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// unindexedNotes are the notes= flags of RESULT lines marking unindexed
+// searches
+var unindexedNotes = map[string]bool{
+	"U": true,
+	"A": true,
+	"F": true,
+}
+
+// unindexedSearch is an unindexed search as listed by the unindexed search
+// API
+type unindexedSearch struct {
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client"`
+	Conn     uint64    `json:"conn"`
+	Op       int64     `json:"op"`
+	Base     string    `json:"base"`
+	Scope    string    `json:"scope"`
+	Filter   string    `json:"filter"`
+	Notes    string    `json:"notes"`
+	Entries  string    `json:"nentries"`
+	Duration string    `json:"etime"`
+}
+
+// unindexedSearches keeps the most recent unindexed searches
+type unindexedSearches struct {
+	mu     sync.Mutex
+	recent []unindexedSearch
+	next   int
+	full   bool
+}
+
+func newUnindexedSearches(limit int) *unindexedSearches {
+	return &unindexedSearches{
+		recent: make([]unindexedSearch, limit),
+	}
+}
+
+// add remembers a search, overwriting the oldest one once the limit is
+// reached
+func (u *unindexedSearches) add(s unindexedSearch) {
+	if len(u.recent) == 0 {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.recent[u.next] = s
+	u.next = (u.next + 1) % len(u.recent)
+	if u.next == 0 {
+		u.full = true
+	}
+}
+
+// list returns the remembered searches, newest first
+func (u *unindexedSearches) list() []unindexedSearch {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	n := u.next
+	if u.full {
+		n = len(u.recent)
+	}
+
+	searches := make([]unindexedSearch, 0, n)
+	for i := 1; i <= n; i++ {
+		searches = append(searches, u.recent[(u.next-i+len(u.recent))%len(u.recent)])
+	}
+	return searches
+}
+
+// handler serves the recent unindexed searches as JSON
+func (u *unindexedSearches) handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(u.list()); err != nil {
+			log.WithError(err).Error("failed to write unindexed search listing")
+		}
+	}
+}
+
+// filterAttributes returns the sorted, lower case set of attributes used in
+// an LDAP filter, e.g. "cn,objectclass" for (&(objectClass=person)(cn=j*))
+func filterAttributes(filter string) string {
+	seen := map[string]bool{}
+	for i := 0; i < len(filter); i++ {
+		if filter[i] != '(' {
+			continue
+		}
+
+		start := i + 1
+		end := start
+		for end < len(filter) && isAttributeChar(filter[end]) {
+			end++
+		}
+		if end == start || end == len(filter) || !strings.ContainsRune("=~<>:", rune(filter[end])) {
+			continue
+		}
+
+		attribute := strings.ToLower(filter[start:end])
+		if j := strings.IndexByte(attribute, ';'); j >= 0 {
+			attribute = attribute[:j]
+		}
+		seen[attribute] = true
+	}
+
+	attributes := make([]string, 0, len(seen))
+	for attribute := range seen {
+		attributes = append(attributes, attribute)
+	}
+	sort.Strings(attributes)
+	return strings.Join(attributes, ",")
+}
+
+func isAttributeChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == ';'
+}
+
+// unindexedNotesOf returns the unindexed notes= flags of a RESULT, which may
+// be combined with others like notes=U,P
+func unindexedNotesOf(res accessLogEvent) []string {
+	var notes []string
+	for _, note := range strings.Split(res.attrs["notes"], ",") {
+		if unindexedNotes[note] {
+			notes = append(notes, note)
+		}
+	}
+	return notes
+}